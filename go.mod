@@ -1,10 +1,15 @@
 module github.com/Johnw7789/Go-iClient
 
-go 1.18
+go 1.21
 
 require (
 	github.com/bogdanfinn/fhttp v0.5.28
 	github.com/tidwall/gjson v1.17.1
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/metric v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/sdk/metric v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
 	golang.org/x/crypto v0.14.0
 )
 
@@ -12,6 +17,8 @@ require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
 	github.com/bogdanfinn/utls v1.6.1 // indirect
 	github.com/cloudflare/circl v1.3.6 // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/klauspost/compress v1.16.7 // indirect
 	github.com/quic-go/quic-go v0.37.4 // indirect
 	github.com/tam7t/hpkp v0.0.0-20160821193359-2b70b4024ed5 // indirect
@@ -24,5 +31,5 @@ require (
 require (
 	github.com/bogdanfinn/tls-client v1.7.5
 	github.com/google/uuid v1.6.0
-	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
 )
@@ -0,0 +1,142 @@
+package icloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// * AccountInfo() returns the name, Apple ID and aliases, country, locale, iCloud+ status,
+// family membership and enabled webservices of the logged in account. Check
+// HideMyEmailEnabled on the result before calling ReserveHME. The family details are
+// fetched on a best-effort basis: if Apple fails to return them, the family fields are left
+// zero and the rest of the info is still returned.
+func (c *Client) AccountInfo() (*AccountInfo, error) {
+	return c.AccountInfoContext(context.Background())
+}
+
+// AccountInfoContext is like AccountInfo but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) AccountInfoContext(ctx context.Context) (_ *AccountInfo, err error) {
+	ctx, op := c.startOperation(ctx, "account.Info")
+	defer func() { op.end(err) }()
+
+	_, dsid := c.accountIDs()
+	if dsid == "" {
+		return nil, ErrNotAuthenticated
+	}
+
+	// A resumed session has no dsInfo yet; accountLogin returns it for the stored tokens.
+	cached, err := c.cachedAccountInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := cached
+	info.AppleIDAliases = append([]string(nil), cached.AppleIDAliases...)
+	info.Webservices = c.Webservices()
+
+	// the family endpoint fails for some accounts that are fine otherwise, e.g. child accounts
+	family, err := c.reqFamilyDetails(ctx)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err == nil {
+		info.FamilyMember = family.IsMemberOfFamily
+		info.FamilyOrganizer = family.IsMemberOfFamily && family.Family.Organizer == dsid
+		info.FamilyMembers = family.Family.Members
+	}
+
+	return &info, nil
+}
+
+// * StorageUsage() returns the account's iCloud storage quota, broken down by Photos, Mail,
+// Backups, Documents and so on
+func (c *Client) StorageUsage() (*StorageUsage, error) {
+	return c.StorageUsageContext(context.Background())
+}
+
+// StorageUsageContext is like StorageUsage but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) StorageUsageContext(ctx context.Context) (_ *StorageUsage, err error) {
+	ctx, op := c.startOperation(ctx, "account.StorageUsage")
+	defer func() { op.end(err) }()
+
+	resp, err := c.send(ctx, accountStorage, func() (*http.Request, error) {
+		u, err := c.endpointURL(accountStorage)
+		if err != nil {
+			return nil, err
+		}
+
+		frameID, dsid := c.accountIDs()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(u, frameID, dsid), bytes.NewReader([]byte("{}")))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set(HdrContentType, "application/json")
+		req.Header.Set("origin", c.currentRegion().HomeOrigin)
+		req.Header.Set("referer", c.currentRegion().HomeOrigin+"/")
+		req.Header.Set("accept", "*/*")
+
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, readAPIError(accountStorage, resp)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage StorageUsage
+	if err := json.Unmarshal(bodyBytes, &usage); err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+func (c *Client) reqFamilyDetails(ctx context.Context) (*familyDetailsResp, error) {
+	resp, err := c.send(ctx, accountFamily, func() (*http.Request, error) {
+		u, err := c.endpointURL(accountFamily)
+		if err != nil {
+			return nil, err
+		}
+
+		frameID, dsid := c.accountIDs()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(u, frameID, dsid), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("origin", c.currentRegion().HomeOrigin)
+		req.Header.Set("referer", c.currentRegion().HomeOrigin+"/")
+		req.Header.Set("accept", "*/*")
+
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, readAPIError(accountFamily, resp)
+	}
+
+	var family familyDetailsResp
+	if err := json.NewDecoder(resp.Body).Decode(&family); err != nil {
+		return nil, fmt.Errorf("decode family details: %w", err)
+	}
+
+	return &family, nil
+}
@@ -0,0 +1,37 @@
+package icloud_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+)
+
+func TestAccountInfoWithoutFamilyDetails(t *testing.T) {
+	srv := newSessionServer(t)
+	client := loggedInClient(t, srv, "user@icloud.com")
+
+	srv.InjectFailure(icloudtest.Failure{Endpoint: "account.family", Status: 503})
+
+	info, err := client.AccountInfo()
+	if err != nil {
+		t.Fatalf("AccountInfo = %v, want the info without the family details", err)
+	}
+	if srv.Calls("account.family") == 0 {
+		t.Fatal("the family details were not requested")
+	}
+	if info.AppleID != "user@icloud.com" || !info.ICloudPlus || len(info.Webservices) == 0 {
+		t.Errorf("AccountInfo = %+v, want the account's details", info)
+	}
+	if info.FamilyMember || info.FamilyOrganizer || info.FamilyMembers != nil {
+		t.Errorf("family fields = %t, %t, %v, want them zero", info.FamilyMember, info.FamilyOrganizer, info.FamilyMembers)
+	}
+
+	// a cancelled call still fails, rather than returning the info without the family
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.AccountInfoContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("AccountInfoContext with a cancelled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,130 @@
+package icloud_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+)
+
+// TestMutationsReturnAPIError checks that failed HME and mail mutations report the failure
+// as an *icloud.APIError, whether Apple answers with an error status or with a success
+// status and an error body.
+func TestMutationsReturnAPIError(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+
+	err := srv.AddAccount(icloudtest.Account{
+		AppleID:    "user@icloud.com",
+		Password:   "password",
+		ICloudPlus: true,
+		HMEs:       []icloud.HmeEmail{{AnonymousID: "anon-1", Hme: "quiet.fox@icloud.com", IsActive: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := loggedInClient(t, srv, "user@icloud.com")
+
+	deactivate := func() error {
+		_, err := client.DeactivateHME("anon-1")
+		return err
+	}
+	sendDraft := func() error {
+		_, err := client.SendDraft("draft-1")
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		failure  *icloudtest.Failure
+		endpoint string
+
+		wantStatus    int
+		wantCode      string
+		wantRetryable bool
+	}{
+		{
+			name:          "deactivate server error",
+			call:          deactivate,
+			failure:       &icloudtest.Failure{Endpoint: "hme.deactivate", Status: 503, Times: 1},
+			endpoint:      "hme.deactivate",
+			wantStatus:    503,
+			wantRetryable: true,
+		},
+		{
+			name:       "deactivate error body",
+			call:       deactivate,
+			failure:    &icloudtest.Failure{Endpoint: "hme.deactivate", Status: 200, Body: `{"success":false,"error":{"errorCode":"-41003","errorMessage":"Deactivation failed"}}`, Times: 1},
+			endpoint:   "hme.deactivate",
+			wantStatus: 200,
+			wantCode:   "-41003",
+		},
+		{
+			name: "deactivate unknown address",
+			call: func() error {
+				_, err := client.DeactivateHME("anon-missing")
+				return err
+			},
+			endpoint:   "hme.deactivate",
+			wantStatus: 200,
+			wantCode:   "-41011",
+		},
+		{
+			name:          "send server error",
+			call:          sendDraft,
+			failure:       &icloudtest.Failure{Endpoint: "mail.send", Status: 502, Times: 1},
+			endpoint:      "mail.send",
+			wantStatus:    502,
+			wantRetryable: true,
+		},
+		{
+			name:       "send error body",
+			call:       sendDraft,
+			failure:    &icloudtest.Failure{Endpoint: "mail.send", Status: 200, Body: `{"error":{"code":"SEND_FAILED","message":"Message could not be sent"}}`, Times: 1},
+			endpoint:   "mail.send",
+			wantStatus: 200,
+			wantCode:   "SEND_FAILED",
+		},
+		{
+			name:       "send unknown draft",
+			call:       sendDraft,
+			endpoint:   "mail.send",
+			wantStatus: 404,
+			wantCode:   "NOT_FOUND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.failure != nil {
+				srv.InjectFailure(*tt.failure)
+			}
+			calls := srv.Calls(tt.endpoint)
+
+			err := tt.call()
+
+			var apiErr *icloud.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an *icloud.APIError", err)
+			}
+			if apiErr.Endpoint != tt.endpoint || apiErr.StatusCode != tt.wantStatus || apiErr.Code != tt.wantCode {
+				t.Errorf("endpoint, status, code = %q, %d, %q, want %q, %d, %q",
+					apiErr.Endpoint, apiErr.StatusCode, apiErr.Code, tt.endpoint, tt.wantStatus, tt.wantCode)
+			}
+			if apiErr.Retryable != tt.wantRetryable {
+				t.Errorf("Retryable = %t, want %t", apiErr.Retryable, tt.wantRetryable)
+			}
+
+			// mutations are not retried by default, even when Apple calls the failure retryable
+			if n := srv.Calls(tt.endpoint) - calls; n != 1 {
+				t.Errorf("sent %d requests, want 1", n)
+			}
+		})
+	}
+
+	if hmes := srv.HMEs("user@icloud.com"); len(hmes) != 1 || !hmes[0].IsActive {
+		t.Errorf("a failed deactivation changed the address: %+v", hmes)
+	}
+}
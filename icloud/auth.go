@@ -3,6 +3,7 @@ package icloud
 import (
 	"bytes"
 	"context"
+	b64 "encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,45 +12,55 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Johnw7789/Go-iClient/internal/srp"
 	http "github.com/bogdanfinn/fhttp"
 	"github.com/google/uuid"
 )
 
 // * Generate a new frameId and clientId, then init the session
-func (c *Client) authStart() (err error) {
+func (c *Client) authStart(ctx context.Context) (err error) {
+	c.mu.Lock()
 	c.frameId = strings.ToLower(uuid.New().String())
 	c.clientId = OAuthClientID
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(endpoints[authStart], c.frameId, c.frameId, c.clientId, c.frameId), nil)
+	// scnt and the session id belong to a single sign in attempt and must not be replayed
+	c.scnt = ""
+	c.sessionID = ""
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(c.regionURL(authStart), c.frameId, c.localeID(), c.frameId, c.clientId, c.currentRegion().HomeOrigin, c.frameId), nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set(HdrAccept, "*/*")
-	req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0")
+	req.Header.Add("User-Agent", c.userAgent)
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.roundTrip(authStart, req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return readAPIError(authStart, resp)
 	}
 
+	c.mu.Lock()
 	c.authAttr = resp.Header.Get("X-Apple-Auth-Attributes")
+	c.mu.Unlock()
 
 	return nil
 }
 
 // * Submit the user's email to Apple
-func (c *Client) authFederate(email string) error {
+func (c *Client) authFederate(ctx context.Context, email string) error {
 	var body io.Reader
 
 	data := `{"accountName":"` + email + `","rememberMe":true}`
 	body = bytes.NewReader([]byte(data))
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[authFederate], body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.regionURL(authFederate), body)
 	if err != nil {
 		return err
 	}
@@ -57,20 +68,33 @@ func (c *Client) authFederate(email string) error {
 	req.Header.Set(HdrContentType, "application/json")
 	req.Header = c.updateRequestHeaders(req.Header.Clone())
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.roundTrip(authFederate, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
 
+	if region, ok := c.regionRedirect(bodyBytes); ok {
+		c.mu.Lock()
+		c.region = region
+		c.mu.Unlock()
+		return ErrRegionChanged
+	}
+
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return newAPIError(authFederate, resp.StatusCode, bodyBytes)
 	}
 
 	return nil
 }
 
 // * Initiate the authentication process, get the salt and B from the server
-func (c *Client) authInit(A, email string) (AuthInitResp, error) {
+func (c *Client) authInit(ctx context.Context, A, email string) (AuthInitResp, error) {
 	var body io.Reader
 
 	reqBody := AuthInitReq{
@@ -89,7 +113,7 @@ func (c *Client) authInit(A, email string) (AuthInitResp, error) {
 
 	body = bytes.NewReader(data)
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[authInit], body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.regionURL(authInit), body)
 	if err != nil {
 		return AuthInitResp{}, err
 	}
@@ -97,10 +121,15 @@ func (c *Client) authInit(A, email string) (AuthInitResp, error) {
 	req.Header.Set(HdrContentType, "application/json")
 	req.Header = c.updateRequestHeaders(req.Header.Clone())
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.roundTrip(authInit, req)
 	if err != nil {
 		return AuthInitResp{}, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return AuthInitResp{}, readAPIError(authInit, resp)
+	}
 
 	var authInitResp AuthInitResp
 	if err := json.NewDecoder(resp.Body).Decode(&authInitResp); err != nil {
@@ -110,14 +139,29 @@ func (c *Client) authInit(A, email string) (AuthInitResp, error) {
 	return authInitResp, nil
 }
 
-// authComplete completes the authentication process and sends the SRP data.
-func (c *Client) authComplete(email, C, M1, M2 string, otpProvider OTPProvider) error {
+// authComplete completes the authentication process and sends the SRP data. If the client
+// holds a trust token from a previous login it is sent along, which lets Apple accept the
+// sign in without a 2FA challenge. It reports whether two-factor authentication was required.
+//
+// Once Apple accepts the client proof M1, the server's own proof M2 from the response is
+// checked against the one computed locally, so a server that does not know the password
+// verifier (e.g. an intercepting proxy) is rejected with ErrServerProofMismatch. A response
+// without a proof is accepted with a warning, unless c.StrictServerProof is set.
+func (c *Client) authComplete(ctx context.Context, email, C string, client *srp.SRPClient, handler TwoFactorHandler) (bool, error) {
 	var body io.Reader
 
+	M1 := b64.StdEncoding.EncodeToString(client.M1)
+	M2 := b64.StdEncoding.EncodeToString(client.M2)
+
+	trustTokens := []string{}
+	if c.trustToken != "" {
+		trustTokens = append(trustTokens, c.trustToken)
+	}
+
 	reqBody := AuthCompleteReq{
 		AccountName: email,
 		RememberMe:  true,
-		TrustTokens: []string{},
+		TrustTokens: trustTokens,
 		M1:          M1,
 		C:           C,
 		M2:          M2,
@@ -125,198 +169,205 @@ func (c *Client) authComplete(email, C, M1, M2 string, otpProvider OTPProvider)
 
 	data, err := json.Marshal(reqBody)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	body = bytes.NewReader(data)
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[authComplete], body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.regionURL(authComplete), body)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	req.Header.Set(HdrContentType, "application/json")
 	req.Header = c.updateRequestHeaders(req.Header.Clone())
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.roundTrip(authComplete, req)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	defer resp.Body.Close()
 
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	if resp.StatusCode == 200 || resp.StatusCode == 409 {
+		err := verifyServerProof(bodyBytes, client)
+		if errors.Is(err, ErrServerProofMissing) && !c.StrictServerProof {
+			c.logUnverifiedSignin(ctx)
+			err = nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	apiErr := newAPIError(authComplete, resp.StatusCode, bodyBytes)
+
 	switch code := resp.StatusCode; code {
 	case 200:
-		return nil
+		// the trust token was accepted, the session token is issued directly
+		c.updateSessionFromResponse(resp)
+		return false, nil
 
 	case 403:
-		return ErrIncorrectUsernamePassword
+		apiErr.Err = ErrIncorrectUsernamePassword
+		if apiErr.hasCode(codeAccountLocked) {
+			apiErr.Err = ErrAccountLocked
+		}
 
 	case 401:
-		return ErrSeverErrorOrInvalidCreds
+		apiErr.Err = ErrSeverErrorOrInvalidCreds
 
 	case 409:
 		// what we typically want to see. This is a 2FA or 2SA challenge
-		return c.handleTwoFactor(resp, otpProvider)
+		return true, c.handleTwoFactor(ctx, resp, handler)
 
 	case 412:
-		return ErrRequiredPrivacyAck
+		apiErr.Err = ErrRequiredPrivacyAck
 
 	case 502:
-		return errors.New("apple server error")
+		// apple server error, reported as is
 
 	default:
-		return ErrUnexpectedSigninResponse
+		apiErr.Err = ErrUnexpectedSigninResponse
 	}
+
+	return false, apiErr
 }
 
-// handleTwoFactor handles Two Factor authentication.
-func (c *Client) handleTwoFactor(signinResp *http.Response, otpProvider OTPProvider) error {
-	// extract `X-Apple-Id-Session-Id` and `scnt` from response
-	c.sessionID = signinResp.Header.Get(HdrXAppleIDSessionID)
-	c.scnt = signinResp.Header.Get(HdrScnt)
+// verifyServerProof checks the M2 proof in the signin/complete response body. An empty
+// body or one without a proof returns ErrServerProofMissing, a proof that does not match
+// ErrServerProofMismatch.
+func verifyServerProof(bodyBytes []byte, client *srp.SRPClient) error {
+	if len(bytes.TrimSpace(bodyBytes)) == 0 {
+		return ErrServerProofMissing
+	}
 
-	// get authentication options
-	req, err := http.NewRequest(http.MethodGet, endpoints[authOptions], nil)
-	if err != nil {
-		return err
+	var completeResp AuthCompleteResp
+	if err := json.Unmarshal(bodyBytes, &completeResp); err != nil {
+		return fmt.Errorf("%w: decode response: %v", ErrServerProofMismatch, err)
+	}
+	if completeResp.M2 == "" {
+		return ErrServerProofMissing
 	}
-	// set required headers
-	req.Header = c.updateRequestHeaders(req.Header.Clone())
 
-	resp, err := c.HttpClient.Do(req)
+	serverM2, err := b64.StdEncoding.DecodeString(completeResp.M2)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrServerProofMismatch, err)
 	}
-	defer resp.Body.Close()
 
-	// Apple returns an updated scnt on every response; it must be forwarded on the next request.
-	if newScnt := resp.Header.Get(HdrScnt); newScnt != "" {
-		c.scnt = newScnt
+	if err := client.CheckM2(serverM2); err != nil {
+		return fmt.Errorf("%w: %v", ErrServerProofMismatch, err)
 	}
 
-	return c.submitTwoFactor(otpProvider)
+	return nil
 }
 
-// submitTwoFactor calls the OTP provider and submits the 2FA code.
-func (c *Client) submitTwoFactor(otpProvider OTPProvider) error {
-	otp, err := otpProvider()
-	if err != nil {
-		return fmt.Errorf("failed to get OTP: %w", err)
-	}
-
-	var body io.Reader
-	var codeType string
+// updateSessionFromResponse stores the session headers Apple returns on a successful sign in.
+func (c *Client) updateSessionFromResponse(resp *http.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	reqBody := TwoFactorCodeFromPhoneRequest{
-		SecurityCode: &SecurityCode{
-			Code: otp,
-		},
+	if token := resp.Header.Get("X-Apple-Session-Token"); token != "" {
+		c.authToken = token
 	}
-
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("marshal request body: %w", err)
+	if token := resp.Header.Get("X-Apple-TwoSV-Trust-Token"); token != "" {
+		c.trustToken = token
 	}
-
-	codeType = "trusteddevice"
-	body = bytes.NewReader(data)
-
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(endpoints[submitSecurityCode], codeType), body)
-	if err != nil {
-		return err
+	if sessionID := resp.Header.Get(HdrXAppleIDSessionID); sessionID != "" {
+		c.sessionID = sessionID
 	}
+	if scnt := resp.Header.Get(HdrScnt); scnt != "" {
+		c.scnt = scnt
+	}
+}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header = c.updateRequestHeaders(req.Header.Clone())
-
-	resp, err := c.HttpClient.Do(req)
+// * Authenticate the user for the various icloud web services using the trust and auth tokens
+func (c *Client) authenticateWeb(ctx context.Context) error {
+	err := c.accountLogin(ctx)
+	if errors.Is(err, ErrRegionChanged) {
+		// the account lives in another region, log in to that region's setup host instead
+		err = c.accountLogin(ctx)
+	}
 	if err != nil {
 		return err
 	}
 
-	if resp.StatusCode != 204 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
+	// for each cookie under idmsa, set it for the icloud domain as well
+	region := c.currentRegion()
+	u, _ := url.Parse(region.AuthOrigin)
+	u2, _ := url.Parse(region.cookieOrigin())
 
-	// var submitResp submitSecurityCodeResponse
-	// err = json.NewDecoder(resp.Body).Decode(&submitResp)
-	// if err != nil {
-	// 	return fmt.Errorf("could not unmarshal response body: %w", err)
-	// }
+	cookies := c.HttpClient.GetCookies(u)
+	c.HttpClient.SetCookies(u2, cookies)
 
-	// for _, svcErr := range submitResp.ServiceErrors {
-	// 	if strings.Contains(svcErr.Message, "Incorrect") {
-	// 		return errors.New("invalid verification code")
-	// 	}
-	// }
+	// Make a second accountLogin to the partition-specific setup server with the dsid.
+	// This binds the iCloud session to the user's assigned partition and elevates
+	// access for partition-local services like Find My. Without this step, fmipservice
+	// returns 450 (re-auth required) even with a valid generic iCloud session.
+	if c.webserviceURL(wsAccount) != "" && c.dsid != "" {
+		if err := c.authenticateWebPartition(ctx); err != nil {
+			// Non-fatal: basic iCloud session still works; Find My may require re-auth.
+			_ = err
+		}
+	}
 
 	return nil
 }
 
-// * Authenticate the user for the various icloud web services using the trust and auth tokens
-func (c *Client) authenticateWeb() error {
-	body := bytes.NewReader([]byte(fmt.Sprintf(`{"dsWebAuthToken":"%s","accountCountryCode":"USA","extended_login":true,"trustToken":"%s"}`, c.authToken, c.trustToken)))
+// accountLogin exchanges the session and trust tokens for the iCloud web session and reads
+// the account's dsid and webservices. If Apple reports that the account belongs to another
+// region, the client switches to it and ErrRegionChanged is returned.
+func (c *Client) accountLogin(ctx context.Context) error {
+	body := bytes.NewReader([]byte(fmt.Sprintf(`{"dsWebAuthToken":"%s","accountCountryCode":"%s","extended_login":true,"trustToken":"%s"}`, c.authToken, c.accountCountry(), c.trustToken)))
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[authWeb], body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.regionURL(authWeb), body)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
+	req.Header.Set("origin", c.currentRegion().HomeOrigin)
+	req.Header.Set("User-Agent", c.userAgent)
 	req.Header.Set("accept", "*/*")
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.roundTrip(authWeb, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if region, ok := c.regionRedirect(bodyBytes); ok {
+		c.mu.Lock()
+		c.region = region
+		c.mu.Unlock()
+		return ErrRegionChanged
+	}
+
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return newAPIError(authWeb, resp.StatusCode, bodyBytes)
 	}
 
 	// Parse dsid and service URLs for use in subsequent service calls.
 	var accountResp struct {
-		DsInfo struct {
-			Dsid string `json:"dsid"`
-		} `json:"dsInfo"`
-		Webservices struct {
-			Account struct {
-				URL string `json:"url"`
-			} `json:"account"`
-			Findme struct {
-				URL string `json:"url"`
-			} `json:"findme"`
-			Contacts struct {
-				URL string `json:"url"`
-			} `json:"contacts"`
-		} `json:"webservices"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&accountResp); err == nil {
-		c.dsid = accountResp.DsInfo.Dsid
-		c.accountURL = accountResp.Webservices.Account.URL
-		c.findMeURL = accountResp.Webservices.Findme.URL
-		c.contactsURL = accountResp.Webservices.Contacts.URL
+		DsInfo      AccountInfo           `json:"dsInfo"`
+		Webservices map[string]Webservice `json:"webservices"`
 	}
-
-	// for each cookie under idmsa.apple.com, set it for icloud.com as well
-	u, _ := url.Parse("https://idmsa.apple.com")
-	u2, _ := url.Parse("https://icloud.com")
-
-	cookies := c.HttpClient.GetCookies(u)
-	c.HttpClient.SetCookies(u2, cookies)
-
-	// Make a second accountLogin to the partition-specific setup server with the dsid.
-	// This binds the iCloud session to the user's assigned partition and elevates
-	// access for partition-local services like Find My. Without this step, fmipservice
-	// returns 450 (re-auth required) even with a valid generic iCloud session.
-	if c.accountURL != "" && c.dsid != "" {
-		if err := c.authenticateWebPartition(); err != nil {
-			// Non-fatal: basic iCloud session still works; Find My may require re-auth.
-			_ = err
-		}
+	if err := json.Unmarshal(bodyBytes, &accountResp); err == nil {
+		c.mu.Lock()
+		c.dsid = accountResp.DsInfo.Dsid
+		c.webservices = accountResp.Webservices
+		c.accountInfo = &accountResp.DsInfo
+		c.mu.Unlock()
 	}
 
 	return nil
@@ -325,31 +376,32 @@ func (c *Client) authenticateWeb() error {
 // authenticateWebPartition performs a second accountLogin to the user's partition-specific
 // setup server (e.g. p52-setup.icloud.com) with the dsid. This is required to elevate
 // the session for partition-local services such as Find My (fmipservice).
-func (c *Client) authenticateWebPartition() error {
+func (c *Client) authenticateWebPartition(ctx context.Context) error {
 	partitionURL := fmt.Sprintf(
 		"%s/setup/ws/1/accountLogin?clientBuildNumber=2602Build17&clientMasteringNumber=2602Build17&clientId=%s&dsid=%s",
-		c.accountURL, c.frameId, c.dsid,
+		c.webserviceURL(wsAccount), c.frameId, c.dsid,
 	)
 
-	body := bytes.NewReader([]byte(fmt.Sprintf(`{"dsWebAuthToken":"%s","accountCountryCode":"USA"}`, c.authToken)))
+	body := bytes.NewReader([]byte(fmt.Sprintf(`{"dsWebAuthToken":"%s","accountCountryCode":"%s"}`, c.authToken, c.accountCountry())))
 
-	req, err := http.NewRequest(http.MethodPost, partitionURL, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, partitionURL, body)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
+	req.Header.Set("origin", c.currentRegion().HomeOrigin)
+	req.Header.Set("User-Agent", c.userAgent)
 	req.Header.Set("accept", "*/*")
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.roundTrip(authWebPartition, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("partition accountLogin: unexpected status code: %d", resp.StatusCode)
+		return readAPIError(authWebPartition, resp)
 	}
 
 	return nil
@@ -359,23 +411,40 @@ func (c *Client) authenticateWebPartition() error {
 // Returns true if the session is active, false if it has expired.
 // Call Login() again if this returns false.
 func (c *Client) ValidateSession() (bool, error) {
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(endpoints[authValidate], c.frameId, c.dsid), nil)
-	if err != nil {
-		return false, err
+	return c.ValidateSessionContext(context.Background())
+}
+
+// ValidateSessionContext is like ValidateSession but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) ValidateSessionContext(ctx context.Context) (_ bool, err error) {
+	ctx, op := c.startOperation(ctx, "auth.ValidateSession")
+	defer func() { op.end(err) }()
+
+	if c.isClosed() {
+		return false, ErrClientClosed
 	}
 
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set(HdrAccept, "*/*")
+	// not sent through send: an expired session is the answer here, not a reason to log in
+	resp, err := c.do(ctx, authValidate, func() (*http.Request, error) {
+		frameID, dsid := c.accountIDs()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(c.regionURL(authValidate), frameID, dsid), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("origin", c.currentRegion().HomeOrigin)
+		req.Header.Set("referer", c.currentRegion().HomeOrigin+"/")
+		req.Header.Set(HdrAccept, "*/*")
 
-	resp, err := c.HttpClient.Do(req)
+		return req, nil
+	})
 	if err != nil {
 		return false, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return false, readAPIError(authValidate, resp)
 	}
 
 	var result struct {
@@ -406,7 +475,7 @@ func (c *Client) KeepAlive(ctx context.Context, interval time.Duration) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			valid, err := c.ValidateSession()
+			valid, err := c.ValidateSessionContext(ctx)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,68 @@
+package icloud_test
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+)
+
+func TestServerProof(t *testing.T) {
+	tests := []struct {
+		name   string
+		acct   icloudtest.Account
+		strict bool
+
+		wantErr  error
+		wantWarn bool
+	}{
+		{name: "valid", acct: icloudtest.Account{TwoFactor: true}},
+		{name: "valid strict", acct: icloudtest.Account{TwoFactor: true}, strict: true},
+		{name: "missing", acct: icloudtest.Account{OmitServerProof: true}, wantWarn: true},
+		{name: "missing with two-factor", acct: icloudtest.Account{OmitServerProof: true, TwoFactor: true}, wantWarn: true},
+		{name: "missing strict", acct: icloudtest.Account{OmitServerProof: true}, strict: true, wantErr: icloud.ErrServerProofMissing},
+		{name: "wrong", acct: icloudtest.Account{WrongServerProof: true}, wantErr: icloud.ErrServerProofMismatch},
+		{name: "wrong strict", acct: icloudtest.Account{WrongServerProof: true}, strict: true, wantErr: icloud.ErrServerProofMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := icloudtest.NewServer()
+			defer srv.Close()
+
+			tt.acct.AppleID = "user@icloud.com"
+			tt.acct.Password = "password"
+			if err := srv.AddAccount(tt.acct); err != nil {
+				t.Fatal(err)
+			}
+
+			var logs logBuffer
+			opts := []icloud.Option{icloud.WithLogger(slog.New(slog.NewTextHandler(&logs, nil)))}
+			if tt.strict {
+				opts = append(opts, icloud.WithStrictServerProof())
+			}
+
+			client, err := srv.NewClient("user@icloud.com", "password", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.Login(func() (string, error) { return "123456", nil })
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, err := client.ExportSession(); !errors.Is(err, icloud.ErrNotAuthenticated) {
+					t.Errorf("a rejected sign in left a session, ExportSession = %v", err)
+				}
+			}
+
+			if warned := strings.Contains(logs.String(), "no server proof"); warned != tt.wantWarn {
+				t.Errorf("warned = %t, want %t", warned, tt.wantWarn)
+			}
+		})
+	}
+}
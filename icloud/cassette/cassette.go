@@ -0,0 +1,93 @@
+// Package cassette records the traffic between an icloud.Client and Apple to a file, and
+// replays it later without network access or credentials. Recordings are redacted before
+// they are written: session tokens, cookies, the dsid, email addresses, names and phone
+// numbers are replaced by placeholders, so cassettes can be checked in.
+//
+// Record a session once against Apple:
+//
+//	client, _ := icloud.NewClientWithOptions(icloud.WithCredentials(username, password))
+//	rec := cassette.NewRecorder(client.HttpClient)
+//	client.HttpClient = rec
+//
+//	// log in and make the calls to capture ...
+//
+//	err := rec.Save("testdata/hme.json")
+//
+// and replay it in tests, logging in with Password:
+//
+//	c, _ := cassette.Load("testdata/hme.json")
+//	client, _ := icloud.NewClientWithOptions(
+//		icloud.WithCredentials("user@example.com", cassette.Password),
+//		icloud.WithTransport(cassette.NewReplayer(c)),
+//	)
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// formatVersion is the version of the cassette file format written by Save.
+const formatVersion = 1
+
+// Cassette is a recorded sequence of requests and their responses.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one request and the response Apple gave to it.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string              `json:"method"`
+	URL    string              `json:"url"`
+	Header map[string][]string `json:"header,omitempty"`
+	Body   string              `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int                 `json:"statusCode"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Body       string              `json:"body,omitempty"`
+}
+
+// Load reads a cassette written by Save.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	if c.Version != formatVersion {
+		return nil, fmt.Errorf("cassette %s: unsupported version %d", path, c.Version)
+	}
+
+	return &c, nil
+}
+
+// Save writes the cassette to path as indented JSON. It is written as is; use
+// Recorder.Save to redact a recording first.
+func (c *Cassette) Save(path string) error {
+	c.Version = formatVersion
+
+	// URLs and bodies are kept readable, without & and < escaped
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
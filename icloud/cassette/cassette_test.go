@@ -0,0 +1,279 @@
+package cassette_test
+
+import (
+	"bytes"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/cassette"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+	http "github.com/bogdanfinn/fhttp"
+)
+
+var update = flag.Bool("update", false, "record testdata/login.json again against icloudtest")
+
+const (
+	appleID  = "john.appleseed@icloud.com"
+	password = "hunter2"
+	dsid     = "11223344556"
+
+	// sampleOrigin replaces the test server's address in the checked-in cassette.
+	sampleOrigin = "http://icloudtest.invalid"
+	samplePath   = "testdata/login.json"
+)
+
+// secretCapture passes requests through to inner and remembers the tokens and cookies
+// Apple handed out, which a saved cassette must not contain.
+type secretCapture struct {
+	inner icloud.Transport
+
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func (s *secretCapture) Do(req *http.Request) (*http.Response, error) {
+	resp, err := s.inner.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range []string{"X-Apple-Session-Token", "X-Apple-TwoSV-Trust-Token", "scnt", "X-Apple-ID-Session-Id"} {
+		if v := resp.Header.Get(name); v != "" {
+			s.secrets[v] = name
+		}
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Value != "" {
+			s.secrets[cookie.Value] = "cookie " + cookie.Name
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *secretCapture) GetCookies(u *url.URL) []*http.Cookie {
+	return s.inner.GetCookies(u)
+}
+
+func (s *secretCapture) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.inner.SetCookies(u, cookies)
+}
+
+func newServer(t *testing.T) *icloudtest.Server {
+	t.Helper()
+
+	srv := icloudtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	err := srv.AddAccount(icloudtest.Account{
+		AppleID:    appleID,
+		Password:   password,
+		Dsid:       dsid,
+		TwoFactor:  true,
+		ICloudPlus: true,
+		Mail:       []icloudtest.Mail{{From: "jane@example.org", Subject: "Hello", Body: "Hi John"}},
+		Contacts:   []icloud.Contact{{FirstName: "Jane", LastName: "Appleseed"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv
+}
+
+func code() (string, error) {
+	return "123456", nil
+}
+
+// useServices makes the calls the cassettes hold after the login.
+func useServices(t *testing.T, client *icloud.Client) {
+	t.Helper()
+
+	if _, err := client.RetrieveHMEList(); err != nil {
+		t.Fatalf("hme: %v", err)
+	}
+
+	inbox, err := client.RetrieveMailInbox(10, 0)
+	if err != nil {
+		t.Fatalf("mail: %v", err)
+	}
+	if len(inbox.ThreadList) != 1 {
+		t.Errorf("inbox has %d threads, want 1", len(inbox.ThreadList))
+	}
+
+	contacts, err := client.GetContacts()
+	if err != nil {
+		t.Fatalf("contacts: %v", err)
+	}
+	if len(contacts) != 1 {
+		t.Errorf("got %d contacts, want 1", len(contacts))
+	}
+}
+
+// record logs in to srv with a recording client and returns the redacted cassette, with
+// the secrets the server handed out mapped to where they came from.
+func record(t *testing.T, srv *icloudtest.Server) (*cassette.Cassette, map[string]string) {
+	t.Helper()
+
+	client, err := srv.NewClient(appleID, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture := &secretCapture{inner: client.HttpClient, secrets: make(map[string]string)}
+	rec := cassette.NewRecorder(capture)
+	client.HttpClient = rec
+
+	if err := client.Login(code); err != nil {
+		t.Fatal(err)
+	}
+	useServices(t, client)
+
+	capture.secrets[dsid] = "dsid"
+	capture.secrets[appleID] = "Apple ID"
+
+	return rec.Cassette(), capture.secrets
+}
+
+// replay logs in from c against a region at origin and makes the recorded calls.
+func replay(t *testing.T, c *cassette.Cassette, origin string) {
+	t.Helper()
+
+	replayer := cassette.NewReplayer(c)
+	client, err := icloud.NewClientWithOptions(
+		icloud.WithCredentials("user1@example.com", cassette.Password),
+		icloud.WithTransport(replayer),
+		icloud.WithRegion(icloud.Region{Name: "cassette", AuthOrigin: origin, SetupOrigin: origin, HomeOrigin: origin, Domain: "icloudtest", Country: "USA"}),
+		icloud.WithRetryPolicy(icloud.RetryPolicy{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Login(code); err != nil {
+		t.Fatalf("replayed login: %v", err)
+	}
+	useServices(t, client)
+
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Errorf("%d interactions were not replayed, the first is %s %s", len(unused), unused[0].Request.Method, unused[0].Request.URL)
+	}
+}
+
+// assertRedacted checks that every secret header and cookie in c holds a placeholder.
+func assertRedacted(t *testing.T, c *cassette.Cassette) {
+	t.Helper()
+
+	secretHeaders := make(map[string]bool)
+	for _, h := range cassette.DefaultRedactor().Headers {
+		secretHeaders[strings.ToLower(h)] = true
+	}
+
+	check := func(where, value string) {
+		if value != "" && !strings.HasPrefix(value, "REDACTED-") {
+			t.Errorf("%s is %q, want a placeholder", where, value)
+		}
+	}
+
+	for _, ia := range c.Interactions {
+		for _, header := range []map[string][]string{ia.Request.Header, ia.Response.Header} {
+			for key, values := range header {
+				for _, v := range values {
+					switch lower := strings.ToLower(key); {
+					case secretHeaders[lower]:
+						check(key+" of "+ia.Request.URL, v)
+					case lower == "set-cookie":
+						_, value, _ := strings.Cut(strings.SplitN(v, ";", 2)[0], "=")
+						check("cookie set by "+ia.Request.URL, value)
+					case lower == "cookie":
+						for _, pair := range strings.Split(v, ";") {
+							_, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+							check("cookie sent to "+ia.Request.URL, value)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRecordRedactReplay(t *testing.T) {
+	srv := newServer(t)
+	recorded, secrets := record(t, srv)
+
+	path := filepath.Join(t.TempDir(), "login.json")
+	if err := recorded.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for secret, from := range secrets {
+		if bytes.Contains(data, []byte(secret)) || bytes.Contains(data, []byte(url.QueryEscape(secret))) {
+			t.Errorf("the cassette contains the %s", from)
+		}
+	}
+	if len(secrets) < 6 {
+		t.Errorf("captured only %d secrets, the login did not hand out its tokens", len(secrets))
+	}
+
+	c, err := cassette.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRedacted(t, c)
+
+	// nothing is left to answer but the cassette
+	srv.Close()
+	replay(t, c, srv.URL)
+}
+
+func TestReplaySample(t *testing.T) {
+	if *update {
+		srv := newServer(t)
+		recorded, _ := record(t, srv)
+
+		path := filepath.Join(t.TempDir(), "login.json")
+		if err := recorded.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data = bytes.ReplaceAll(data, []byte(srv.URL), []byte(sampleOrigin))
+		if err := os.WriteFile(samplePath, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{dsid, appleID, password, url.QueryEscape(appleID)} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("%s contains %q", samplePath, secret)
+		}
+	}
+
+	c, err := cassette.Load(samplePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRedacted(t, c)
+
+	replay(t, c, sampleOrigin)
+}
@@ -0,0 +1,119 @@
+package cassette
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// Recorder is an icloud.Transport that sends requests through another transport and
+// records each request with its response.
+type Recorder struct {
+	// Redactor scrubs the recording when it is saved. DefaultRedactor is used if nil.
+	Redactor *Redactor
+
+	inner icloud.Transport
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns a Recorder sending requests through inner, usually the client's
+// current HttpClient.
+func NewRecorder(inner icloud.Transport) *Recorder {
+	return &Recorder{inner: inner}
+}
+
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.inner.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: recordedHeader(req.Header),
+			Body:   string(reqBody),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     recordedHeader(resp.Header),
+			Body:       string(respBody),
+		},
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+func (r *Recorder) GetCookies(u *url.URL) []*http.Cookie {
+	return r.inner.GetCookies(u)
+}
+
+func (r *Recorder) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	r.inner.SetCookies(u, cookies)
+}
+
+// Cassette returns the redacted recording so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	interactions := make([]Interaction, len(r.interactions))
+	copy(interactions, r.interactions)
+	r.mu.Unlock()
+
+	redactor := r.Redactor
+	if redactor == nil {
+		redactor = DefaultRedactor()
+	}
+
+	return &Cassette{
+		Version:      formatVersion,
+		Interactions: redactor.Redact(interactions),
+	}
+}
+
+// Save writes the redacted recording to path.
+func (r *Recorder) Save(path string) error {
+	return r.Cassette().Save(path)
+}
+
+// recordedHeader copies the headers worth keeping. fhttp's header order keys and the
+// encoding headers, which no longer describe the decoded body, are left out.
+func recordedHeader(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for key, values := range h {
+		switch strings.ToLower(key) {
+		case strings.ToLower(http.HeaderOrderKey), strings.ToLower(http.PHeaderOrderKey),
+			"content-length", "content-encoding", "transfer-encoding":
+			continue
+		}
+		out[key] = append([]string(nil), values...)
+	}
+	return out
+}
@@ -0,0 +1,346 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redactor replaces secrets in recorded interactions with placeholders. A secret found in
+// one place, e.g. the dsid in the accountLogin response, is replaced wherever else it
+// occurs too, including URLs and headers. The same secret always gets the same
+// placeholder, so a replayed client sends back the placeholders it was given and the
+// cassette stays consistent.
+type Redactor struct {
+	// Headers are the request and response headers whose values are secrets, compared
+	// case-insensitively. Cookie values in Cookie and Set-Cookie headers are always
+	// redacted, while the cookie names are kept.
+	Headers []string
+
+	// Fields are the JSON keys whose string values, or string array elements, are secrets.
+	Fields []string
+
+	// Replace maps JSON keys to a fixed value their values are replaced with, for fields
+	// that cannot be replayed anyway, such as the SRP values.
+	Replace map[string]string
+
+	// Emails redacts every email address, wherever it appears.
+	Emails bool
+}
+
+// minSecretLen is the length below which a value is only redacted where it was found,
+// since replacing it everywhere would mangle unrelated text.
+const minSecretLen = 4
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// DefaultRedactor returns a Redactor for the data Apple's web APIs are known to carry:
+// session, trust and sign in tokens, the dsid, Apple ID, names, email addresses and phone
+// numbers. The SRP values exchanged at sign in are blanked; a Replayer answers the sign in
+// with values of its own, see Password.
+func DefaultRedactor() *Redactor {
+	return &Redactor{
+		Headers: []string{
+			"Authorization",
+			"scnt",
+			"X-Apple-ID-Session-Id",
+			"X-Apple-Session-Token",
+			"X-Apple-TwoSV-Trust-Token",
+			"X-Apple-Auth-Attributes",
+			"X-Apple-I-FD-Client-Info",
+			"X-Apple-Repair-Session-Token",
+		},
+		Fields: []string{
+			"dsWebAuthToken", "trustToken", "trustTokens",
+			"dsid", "prsId", "personId",
+			"accountName", "appleId", "appleIdAlias", "appleIdAliases", "iCloudAppleIdAlias",
+			"primaryEmail", "forwardToEmail", "forwardToEmails", "selectedForwardTo", "hme",
+			"fullName", "firstName", "lastName", "name",
+			"numberWithDialCode", "obfuscatedNumber", "phoneNumber",
+		},
+		Replace: map[string]string{
+			"password": "",
+			"a":        "",
+			"m1":       "",
+			"m2":       "",
+			"b":        "",
+			"salt":     "",
+		},
+		Emails: true,
+	}
+}
+
+// redaction is the state of one Redact call.
+type redaction struct {
+	*Redactor
+
+	headers      map[string]bool
+	fields       map[string]bool
+	placeholders map[string]string
+	counts       map[string]int
+}
+
+// Redact returns a redacted copy of interactions.
+func (r *Redactor) Redact(interactions []Interaction) []Interaction {
+	rd := &redaction{
+		Redactor:     r,
+		headers:      make(map[string]bool, len(r.Headers)),
+		fields:       make(map[string]bool, len(r.Fields)),
+		placeholders: make(map[string]string),
+		counts:       make(map[string]int),
+	}
+	for _, h := range r.Headers {
+		rd.headers[strings.ToLower(h)] = true
+	}
+	for _, f := range r.Fields {
+		rd.fields[f] = true
+	}
+
+	// secrets are collected from every interaction first, as one may only be revealed by a
+	// response after it was already sent in an earlier request
+	for _, ia := range interactions {
+		rd.collectHeader(ia.Request.Header)
+		rd.collectHeader(ia.Response.Header)
+		rd.collectBody(ia.Request.Body)
+		rd.collectBody(ia.Response.Body)
+		rd.collectEmails(ia.Request.URL)
+	}
+
+	replacer := rd.replacer()
+
+	out := make([]Interaction, len(interactions))
+	for i, ia := range interactions {
+		out[i] = Interaction{
+			Request: Request{
+				Method: ia.Request.Method,
+				URL:    replacer.Replace(ia.Request.URL),
+				Header: rd.redactHeader(ia.Request.Header, replacer),
+				Body:   rd.redactBody(ia.Request.Body, replacer),
+			},
+			Response: Response{
+				StatusCode: ia.Response.StatusCode,
+				Header:     rd.redactHeader(ia.Response.Header, replacer),
+				Body:       rd.redactBody(ia.Response.Body, replacer),
+			},
+		}
+	}
+
+	return out
+}
+
+// register records value as a secret and returns its placeholder.
+func (rd *redaction) register(label, value string) string {
+	if p, ok := rd.placeholders[value]; ok {
+		return p
+	}
+
+	var p string
+	if emailPattern.FindString(value) == value {
+		rd.counts["email"]++
+		p = fmt.Sprintf("user%d@example.com", rd.counts["email"])
+	} else {
+		label = strings.ToLower(label)
+		rd.counts[label]++
+		p = fmt.Sprintf("REDACTED-%s-%d", label, rd.counts[label])
+	}
+
+	rd.placeholders[value] = p
+	return p
+}
+
+func (rd *redaction) collectHeader(header map[string][]string) {
+	for key, values := range header {
+		lower := strings.ToLower(key)
+		for _, v := range values {
+			switch {
+			case lower == "cookie":
+				for _, pair := range strings.Split(v, ";") {
+					rd.collectCookie(pair)
+				}
+			case lower == "set-cookie":
+				rd.collectCookie(strings.SplitN(v, ";", 2)[0])
+			case rd.headers[lower]:
+				if v != "" {
+					rd.register(key, v)
+				}
+			default:
+				rd.collectEmails(v)
+			}
+		}
+	}
+}
+
+// collectCookie registers the value of a name=value pair.
+func (rd *redaction) collectCookie(pair string) {
+	name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+	if !ok {
+		return
+	}
+	value = strings.Trim(value, `"`)
+	if value != "" {
+		rd.register("cookie-"+name, value)
+	}
+}
+
+func (rd *redaction) collectBody(body string) {
+	if body == "" {
+		return
+	}
+
+	if v, ok := decodeJSON(body); ok {
+		rd.collectJSON("", v)
+	}
+	rd.collectEmails(body)
+}
+
+// collectJSON registers the secrets under the redacted fields of v.
+func (rd *redaction) collectJSON(key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			rd.collectJSON(k, child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			rd.collectJSON(key, child)
+		}
+	case string:
+		if rd.fields[key] && v != "" {
+			rd.register(key, v)
+		}
+	}
+}
+
+func (rd *redaction) collectEmails(s string) {
+	if !rd.Emails {
+		return
+	}
+	if unescaped, err := url.QueryUnescape(s); err == nil {
+		s = unescaped
+	}
+	for _, email := range emailPattern.FindAllString(s, -1) {
+		rd.register("email", email)
+	}
+}
+
+// replacer replaces every secret long enough to be matched safely, in plain and in URL
+// encoded form. Longer secrets come first so a secret containing another is replaced
+// whole.
+func (rd *redaction) replacer() *strings.Replacer {
+	secrets := make([]string, 0, len(rd.placeholders))
+	for secret := range rd.placeholders {
+		if len(secret) >= minSecretLen {
+			secrets = append(secrets, secret)
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+
+	var pairs []string
+	for _, secret := range secrets {
+		p := rd.placeholders[secret]
+		pairs = append(pairs, secret, p)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			pairs = append(pairs, escaped, url.QueryEscape(p))
+		}
+	}
+
+	return strings.NewReplacer(pairs...)
+}
+
+func (rd *redaction) redactHeader(header map[string][]string, replacer *strings.Replacer) map[string][]string {
+	if header == nil {
+		return nil
+	}
+
+	out := make(map[string][]string, len(header))
+	for key, values := range header {
+		lower := strings.ToLower(key)
+		for _, v := range values {
+			if rd.headers[lower] && v != "" {
+				v = rd.placeholders[v]
+			} else {
+				v = replacer.Replace(v)
+			}
+			out[key] = append(out[key], v)
+		}
+	}
+	return out
+}
+
+func (rd *redaction) redactBody(body string, replacer *strings.Replacer) string {
+	if body == "" {
+		return body
+	}
+
+	v, ok := decodeJSON(body)
+	if !ok {
+		return replacer.Replace(body)
+	}
+
+	v = rd.redactJSON("", v)
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return replacer.Replace(body)
+	}
+
+	return replacer.Replace(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// redactJSON replaces the values of redacted fields in v, including short ones the
+// replacer leaves alone.
+func (rd *redaction) redactJSON(key string, v interface{}) interface{} {
+	if fixed, ok := rd.Replace[key]; ok {
+		if _, isString := v.(string); isString {
+			return fixed
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = rd.redactJSON(k, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rd.redactJSON(key, child)
+		}
+		return v
+	case string:
+		if rd.fields[key] && v != "" {
+			return rd.placeholders[v]
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// decodeJSON decodes body if it is a JSON object or array, keeping numbers as written.
+func decodeJSON(body string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
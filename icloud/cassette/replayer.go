@@ -0,0 +1,133 @@
+package cassette
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/bogdanfinn/fhttp/cookiejar"
+)
+
+// ErrNoInteraction is returned by a Replayer for a request the cassette has no unused
+// response for.
+var ErrNoInteraction = errors.New("cassette: no recorded response")
+
+// Replayer is an icloud.Transport that answers requests from a cassette instead of the
+// network. A request gets the response of the first interaction not replayed yet with the
+// same method, host and path; query parameters and bodies are not compared, since they
+// carry per-run values such as the client id. Cookies set by the recorded responses are
+// kept in a jar, as a real transport would.
+//
+// The sign in is not replayed as recorded: the Replayer answers signin/init and
+// signin/complete as Apple would for an account with the password Password, so the
+// replayed client has to log in with it and can verify the server's proof.
+type Replayer struct {
+	jar *cookiejar.Jar
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+	signin       *signin
+}
+
+// NewReplayer returns a Replayer for the interactions in c.
+func NewReplayer(c *Cassette) *Replayer {
+	jar, _ := cookiejar.New(nil) // never fails without options
+
+	return &Replayer{
+		jar:          jar,
+		interactions: c.Interactions,
+		used:         make([]bool, len(c.Interactions)),
+	}
+}
+
+func (r *Replayer) Do(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		reqBody, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+
+	ia, err := r.next(req)
+	if err != nil {
+		return nil, err
+	}
+
+	status, body := r.answerSignin(req.URL.Path, reqBody, ia.Response)
+
+	header := make(http.Header, len(ia.Response.Header))
+	for key, values := range ia.Response.Header {
+		header[key] = append([]string(nil), values...)
+	}
+	if header.Get("Content-Length") != "" {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		r.jar.SetCookies(req.URL, cookies)
+	}
+
+	return resp, nil
+}
+
+// next marks and returns the interaction that answers req.
+func (r *Replayer) next(req *http.Request) (Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, ia := range r.interactions {
+		if r.used[i] || ia.Request.Method != req.Method {
+			continue
+		}
+
+		u, err := url.Parse(ia.Request.URL)
+		if err != nil || !strings.EqualFold(u.Host, req.URL.Host) || u.Path != req.URL.Path {
+			continue
+		}
+
+		r.used[i] = true
+		return ia, nil
+	}
+
+	return Interaction{}, fmt.Errorf("%w for %s %s://%s%s", ErrNoInteraction, req.Method, req.URL.Scheme, req.URL.Host, req.URL.Path)
+}
+
+// Unused returns the recorded interactions that were not replayed, e.g. to check that a
+// test made every call the cassette expects.
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, ia := range r.interactions {
+		if !r.used[i] {
+			unused = append(unused, ia)
+		}
+	}
+	return unused
+}
+
+func (r *Replayer) GetCookies(u *url.URL) []*http.Cookie {
+	return r.jar.Cookies(u)
+}
+
+func (r *Replayer) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	r.jar.SetCookies(u, cookies)
+}
@@ -0,0 +1,147 @@
+package cassette
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/internal/srp"
+)
+
+// Password is the password a replayed client has to log in with. The SRP proofs of a
+// recording only fit the secrets of the client that made it, so a Replayer plays Apple's
+// part of the sign in itself, with an account whose password is Password.
+const Password = "cassette-password"
+
+// defaultIterations is the PBKDF2 iteration count used if the recording has none.
+const defaultIterations = 1000
+
+// signin is the Replayer's side of a sign in between signin/init and signin/complete.
+type signin struct {
+	server *srp.SRPServer
+	salt   []byte
+	A      []byte
+}
+
+// incorrectCredentials is Apple's answer to a sign in with the wrong password.
+const incorrectCredentials = `{"serviceErrors":[{"code":"-20101","message":"Your Apple ID or password was incorrect."}]}`
+
+// answerSignin replaces the SRP values of the recorded response to a signin/init or
+// signin/complete request with ones for Password, returning the status and body to
+// replay. Other responses are returned as recorded.
+func (r *Replayer) answerSignin(path string, reqBody []byte, recorded Response) (int, string) {
+	switch {
+	case strings.HasSuffix(path, "/signin/init"):
+		return recorded.StatusCode, r.signinInit(reqBody, recorded.Body)
+	case strings.HasSuffix(path, "/signin/complete"):
+		return r.signinComplete(reqBody, recorded)
+	default:
+		return recorded.StatusCode, recorded.Body
+	}
+}
+
+// signinInit starts a sign in for the client's A and answers with a fresh B, keeping the
+// recorded protocol and iteration count.
+func (r *Replayer) signinInit(reqBody []byte, body string) string {
+	var req icloud.AuthInitReq
+	if err := json.Unmarshal(reqBody, &req); err != nil {
+		return body
+	}
+	A, err := b64.StdEncoding.DecodeString(req.A)
+	if err != nil {
+		return body
+	}
+
+	resp, ok := decodeJSON(body)
+	fields, isObject := resp.(map[string]interface{})
+	if !ok || !isObject {
+		fields = make(map[string]interface{})
+	}
+
+	protocol, _ := fields["protocol"].(string)
+	if protocol == "" {
+		protocol = srp.ProtocolS2K
+	}
+
+	iterations := defaultIterations
+	if n, ok := fields["iteration"].(json.Number); ok {
+		if i, err := n.Int64(); err == nil && i > 0 {
+			iterations = int(i)
+		}
+	}
+
+	// the redacted salt is blank, any fixed one will do
+	salt, _ := fields["salt"].(string)
+	saltBytes, err := b64.StdEncoding.DecodeString(salt)
+	if err != nil || len(saltBytes) == 0 {
+		saltBytes = make([]byte, 16)
+	}
+
+	verifier, err := srp.AppleVerifier(protocol, []byte(Password), saltBytes, iterations)
+	if err != nil {
+		return body
+	}
+	server := srp.NewSRPServer(srp.AppleParams(), verifier, nil)
+
+	r.mu.Lock()
+	r.signin = &signin{server: server, salt: saltBytes, A: A}
+	r.mu.Unlock()
+
+	fields["protocol"] = protocol
+	fields["iteration"] = iterations
+	fields["salt"] = b64.StdEncoding.EncodeToString(saltBytes)
+	fields["b"] = b64.StdEncoding.EncodeToString(server.GetBBytes())
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return string(data)
+}
+
+// signinComplete checks the client's proof against the sign in started by signinInit. A
+// wrong proof is answered as Apple answers a wrong password; a right one gets the
+// recorded response with the server's proof in it.
+func (r *Replayer) signinComplete(reqBody []byte, recorded Response) (int, string) {
+	r.mu.Lock()
+	in := r.signin
+	r.signin = nil
+	r.mu.Unlock()
+
+	if in == nil {
+		return recorded.StatusCode, recorded.Body
+	}
+
+	var req icloud.AuthCompleteReq
+	if err := json.Unmarshal(reqBody, &req); err != nil {
+		return 400, recorded.Body
+	}
+
+	M1, err := b64.StdEncoding.DecodeString(req.M1)
+	if err != nil {
+		return 400, recorded.Body
+	}
+
+	M2, err := in.server.ProcessClientProof([]byte(req.AccountName), in.salt, in.A, M1)
+	if err != nil {
+		return 403, incorrectCredentials
+	}
+
+	if recorded.StatusCode != 200 && recorded.StatusCode != 409 {
+		return recorded.StatusCode, recorded.Body
+	}
+
+	resp, ok := decodeJSON(recorded.Body)
+	fields, isObject := resp.(map[string]interface{})
+	if !ok || !isObject {
+		fields = make(map[string]interface{})
+	}
+	fields["m2"] = b64.StdEncoding.EncodeToString(M2)
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return recorded.StatusCode, recorded.Body
+	}
+	return recorded.StatusCode, string(data)
+}
@@ -2,21 +2,67 @@ package icloud
 
 import (
 	"encoding/json"
-
-	tls_client "github.com/bogdanfinn/tls-client"
-	"github.com/bogdanfinn/tls-client/profiles"
+	"log/slog"
+	"sync"
+	"time"
 )
 
 // OTPProvider is a callback function that prompts for and returns a 2FA code.
 // It is called during Login when two-factor authentication is required.
 type OTPProvider func() (string, error)
 
+// PhoneSelector is called during Login when two-factor authentication is required and the
+// account has trusted phone numbers. It returns the ID of the number to send the code to and
+// the delivery mode (TwoFactorModeSMS or TwoFactorModeVoice). Returning a zero ID uses the
+// code pushed to the account's trusted devices instead. LoginWithHandler does not use it;
+// a TwoFactorHandler chooses the delivery method itself.
+type PhoneSelector func(numbers []TrustedPhoneNumber) (phoneID int, mode string, err error)
+
+// * Client is an authenticated iCloud session. A Client is safe for concurrent use by
+// multiple goroutines once it is configured: service calls (HME, Mail, Find My, Contacts,
+// account) can run in parallel, while Login, LoadSession, Logout and automatic
+// re-authentication are serialized and never run at the same time as each other. The
+// exported fields must not be changed while the client is in use.
 type Client struct {
-	HttpClient tls_client.HttpClient
+	// HttpClient sends the client's requests and holds its cookies. It is a
+	// tls_client.HttpClient unless WithTransport was used.
+	HttpClient Transport
 
 	Username string
 	Password string
 
+	// SessionStore, when set, is used by Login to resume a saved session and to save
+	// the session after a full login.
+	SessionStore SessionStore
+
+	// PhoneSelector, when set, lets the caller receive the 2FA code by SMS or voice call.
+	// Accounts without a trusted device always use their default trusted phone number.
+	PhoneSelector PhoneSelector
+
+	// AutoReauth makes service calls that fail because the session expired (HTTP 450 or
+	// 421) re-authenticate and replay the request once, instead of returning
+	// ErrSessionExpired. It uses the stored trust token and, if needed, a full login with
+	// the credentials and two-factor handler given to the last Login call.
+	AutoReauth bool
+
+	// StrictServerProof makes a sign in fail with ErrServerProofMissing when Apple's response
+	// carries no server proof (M2). By default the sign in goes ahead unverified and a warning
+	// is logged to Logger. A proof that does not match always fails.
+	StrictServerProof bool
+
+	// RateLimiter, when set, holds back calls that would exceed its per-account limits.
+	// Share one limiter between clients for the same account.
+	RateLimiter *RateLimiter
+
+	// Retry controls how failed service calls are retried. The zero value disables retries;
+	// NewClientWithOptions uses DefaultRetryPolicy.
+	Retry RetryPolicy
+
+	// Logger, when set, records every request the client makes: its endpoint, method, status,
+	// latency and Apple's request IDs, plus the headers at debug level. Tokens, cookies and
+	// other secret headers are redacted, and bodies are never logged.
+	Logger *slog.Logger
+
 	authToken  string
 	trustToken string
 	frameId    string
@@ -27,39 +73,64 @@ type Client struct {
 	scnt       string
 
 	// iCloud partition state (populated after Login)
-	accountURL  string // partition-specific setup URL, e.g. https://p52-setup.icloud.com:443
-	findMeURL   string
+	webservices map[string]Webservice // keyed by service name, see Webservices
+	accountInfo *AccountInfo          // dsInfo from the last accountLogin
 	dsid        string
 	fmServerCtx json.RawMessage
 
 	// Contacts module state
-	contactsURL string
-	syncToken   string
-	prefToken   string
+	syncToken string
+	prefToken string
+
+	// Request settings, see NewClientWithOptions
+	region    Region
+	userAgent string
+	locale    string
+	country   string
+	timezone  string
+	location  *time.Location // timezone, loaded once
+
+	// Re-authentication state, see reauthenticate
+	loginHandler TwoFactorHandler
+	authGen      uint64
+	reauthErr    error
+
+	// closed is set by Logout
+	closed bool
+
+	// telemetry traces and measures requests, nil unless WithTracerProvider or
+	// WithMeterProvider was used
+	telemetry *telemetry
+
+	// authMu serializes everything that signs in, restores or ends the session: Login,
+	// reauthenticate, LoadSession and Logout. mu guards the session and service state
+	// above; fields are written under mu and read through the accessors in state.go.
+	authMu sync.Mutex
+	mu     sync.RWMutex
 }
 
-// * NewClient intializes a new http client and returns a new icloud client
+// * NewClient intializes a new http client and returns a new icloud client. Unlike
+// NewClientWithOptions it does not retry failed calls, as before retries were added.
 func NewClient(username, password string, sniff bool) (*Client, error) {
-	jar := tls_client.NewCookieJar()
-
-	options := []tls_client.HttpClientOption{
-		tls_client.WithTimeoutSeconds(30),
-		tls_client.WithCookieJar(jar),
-		tls_client.WithClientProfile(profiles.Chrome_124),
-	}
+	opts := []Option{WithCredentials(username, password), WithRetryPolicy(RetryPolicy{})}
 
 	if sniff {
-		options = append(options, tls_client.WithProxyUrl("http://localhost:8888"))
+		opts = append(opts, WithProxy(sniffProxyURL))
 	}
 
-	client, err := tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
-	if err != nil {
-		return nil, err
+	return NewClientWithOptions(opts...)
+}
+
+// * NewClientWithStore initializes a new icloud client that loads and saves its session
+// through store. A previously saved session for the account is loaded immediately; Login
+// then only runs the full authentication flow if that session is no longer valid. Like
+// NewClient it does not retry failed calls.
+func NewClientWithStore(username, password string, sniff bool, store SessionStore) (*Client, error) {
+	opts := []Option{WithCredentials(username, password), WithSessionStore(store), WithRetryPolicy(RetryPolicy{})}
+
+	if sniff {
+		opts = append(opts, WithProxy(sniffProxyURL))
 	}
 
-	return &Client{
-		HttpClient: client,
-		Username:   username,
-		Password:   password,
-	}, nil
+	return NewClientWithOptions(opts...)
 }
@@ -0,0 +1,171 @@
+package icloud_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+)
+
+// These tests are meant to be run with -race; they only check that concurrent use of
+// clients works, the race detector checks that it is safe.
+
+func newConcurrencyServer(t *testing.T, accounts int) *icloudtest.Server {
+	t.Helper()
+
+	srv := icloudtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	for i := 0; i < accounts; i++ {
+		err := srv.AddAccount(icloudtest.Account{
+			AppleID:    appleID(i),
+			Password:   "password",
+			ICloudPlus: true,
+			Devices:    []icloud.FMDevice{{ID: "device-1", Name: "iPhone"}},
+			Contacts:   []icloud.Contact{{FirstName: "John", LastName: "Appleseed"}},
+			Mail:       []icloudtest.Mail{{From: "a@example.com", Subject: "Hello", Body: "Hi"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return srv
+}
+
+func appleID(i int) string {
+	return fmt.Sprintf("user%d@icloud.com", i)
+}
+
+func loggedInClient(t *testing.T, srv *icloudtest.Server, id string, opts ...icloud.Option) *icloud.Client {
+	t.Helper()
+
+	client, err := srv.NewClient(id, "password", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// callServices calls one read of each service, returning the first error.
+func callServices(client *icloud.Client) error {
+	if _, err := client.RetrieveHMEList(); err != nil {
+		return fmt.Errorf("hme: %w", err)
+	}
+	if _, err := client.RetrieveMailInbox(10, 0); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	if _, err := client.GetDevices(); err != nil {
+		return fmt.Errorf("findmy: %w", err)
+	}
+	if _, err := client.GetContacts(); err != nil {
+		return fmt.Errorf("contacts: %w", err)
+	}
+	if _, err := client.AccountInfo(); err != nil {
+		return fmt.Errorf("account: %w", err)
+	}
+	return nil
+}
+
+func TestConcurrentLogin(t *testing.T) {
+	const clients = 8
+	srv := newConcurrencyServer(t, clients)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			client, err := srv.NewClient(appleID(i), "password")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := client.Login(nil); err != nil {
+				errs <- fmt.Errorf("%s: %w", appleID(i), err)
+				return
+			}
+			if err := callServices(client); err != nil {
+				errs <- fmt.Errorf("%s: %w", appleID(i), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	srv := newConcurrencyServer(t, 1)
+	client := loggedInClient(t, srv, appleID(0))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := callServices(client); err != nil {
+				errs <- err
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if _, err := client.ReserveHME(fmt.Sprintf("label %d", i), ""); err != nil {
+				errs <- fmt.Errorf("reserve: %w", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := len(srv.HMEs(appleID(0))); got != 8 {
+		t.Errorf("server has %d addresses, want 8", got)
+	}
+}
+
+func TestConcurrentReauth(t *testing.T) {
+	srv := newConcurrencyServer(t, 1)
+	client := loggedInClient(t, srv, appleID(0), icloud.WithAutoReauth())
+
+	for round := 0; round < 3; round++ {
+		srv.ExpireSessions(appleID(0))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := callServices(client); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("round %d: %v", round, err)
+		}
+	}
+
+	if calls := srv.Calls("auth.accountLogin"); calls < 2 {
+		t.Errorf("accountLogin called %d times, want the login and at least one reauth", calls)
+	}
+}
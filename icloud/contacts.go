@@ -1,10 +1,9 @@
 package icloud
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/url"
 	"strings"
 
@@ -15,56 +14,58 @@ import (
 const (
 	coClientBuildNumber = "2604Build17"
 	coClientVersion     = "2.1"
-	coLocale            = "en_US"
 	coOrder             = "last,first"
 )
 
 func (c *Client) buildContactsURL(action string) string {
-	return fmt.Sprintf("%s/co/%s?clientBuildNumber=%s&clientId=%s&clientMasteringNumber=%s&clientVersion=%s&dsid=%s&locale=%s&order=%s", c.contactsURL, action, coClientBuildNumber, c.frameId, coClientBuildNumber, coClientVersion, c.dsid, coLocale, url.QueryEscape(coOrder))
+	frameID, dsid := c.accountIDs()
+	return fmt.Sprintf("%s/co/%s?clientBuildNumber=%s&clientId=%s&clientMasteringNumber=%s&clientVersion=%s&dsid=%s&locale=%s&order=%s", c.webserviceURL(wsContacts), action, coClientBuildNumber, frameID, coClientBuildNumber, coClientVersion, dsid, c.localeID(), url.QueryEscape(coOrder))
 }
 
 func (c *Client) buildContactsURLWithTokens(action string) string {
-	return fmt.Sprintf("%s&prefToken=%s&syncToken=%s", c.buildContactsURL(action), url.QueryEscape(c.prefToken), url.QueryEscape(c.syncToken))
+	syncToken, prefToken := c.contactsTokens()
+	return fmt.Sprintf("%s&prefToken=%s&syncToken=%s", c.buildContactsURL(action), url.QueryEscape(prefToken), url.QueryEscape(syncToken))
+}
+
+// contactsReady reports whether the account's Contacts service is known.
+func (c *Client) contactsReady() bool {
+	_, dsid := c.accountIDs()
+	return c.webserviceURL(wsContacts) != "" && dsid != ""
 }
 
 // GetContacts fetches all contacts from iCloud. On first call, this automatically
 // initializes the contacts session by calling startup. Returns the full contact list.
 func (c *Client) GetContacts() ([]Contact, error) {
-	if c.syncToken == "" || c.prefToken == "" {
-		startupResp, err := c.reqStartup()
+	return c.GetContactsContext(context.Background())
+}
+
+// GetContactsContext is like GetContacts but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) GetContactsContext(ctx context.Context) (_ []Contact, err error) {
+	ctx, op := c.startOperation(ctx, "contacts.List")
+	defer func() { op.end(err) }()
+
+	if syncToken, prefToken := c.contactsTokens(); syncToken == "" || prefToken == "" {
+		startupResp, err := c.reqStartup(ctx)
 		if err != nil {
 			return nil, err
 		}
 		return startupResp.Contacts, nil
 	}
 
-	if c.contactsURL == "" || c.dsid == "" {
+	if !c.contactsReady() {
 		return nil, fmt.Errorf("contacts not available: missing contactsURL or dsid")
 	}
 
-	req, err := http.NewRequest(http.MethodGet, c.buildContactsURLWithTokens("contacts/card/"), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, contactsList, http.MethodGet, func() (string, error) {
+		return c.buildContactsURLWithTokens("contacts/card/"), nil
+	}, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 450 {
-		return nil, ErrSessionExpired
-	}
-
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("get contacts failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, readAPIError(contactsList, resp)
 	}
 
 	var contactsResp ContactsResponse
@@ -72,18 +73,21 @@ func (c *Client) GetContacts() ([]Contact, error) {
 		return nil, fmt.Errorf("decode contacts response: %w", err)
 	}
 
-	if contactsResp.SyncToken != "" {
-		c.syncToken = contactsResp.SyncToken
-	}
-	if contactsResp.PrefToken != "" {
-		c.prefToken = contactsResp.PrefToken
-	}
+	c.setContactsTokens(contactsResp.SyncToken, contactsResp.PrefToken)
 
 	return contactsResp.Contacts, nil
 }
 
 func (c *Client) GetContact(contactID string) (*Contact, error) {
-	contacts, err := c.GetContacts()
+	return c.GetContactContext(context.Background(), contactID)
+}
+
+// GetContactContext is like GetContact but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) GetContactContext(ctx context.Context, contactID string) (_ *Contact, err error) {
+	ctx, op := c.startOperation(ctx, "contacts.Get")
+	defer func() { op.end(err) }()
+
+	contacts, err := c.GetContactsContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -100,11 +104,19 @@ func (c *Client) GetContact(contactID string) (*Contact, error) {
 // CreateContact creates a new contact in iCloud. A contactId UUID is generated
 // automatically by the client. Returns the created contact with etag populated.
 func (c *Client) CreateContact(contact Contact) (*Contact, error) {
-	if err := c.ensureContactsInit(); err != nil {
+	return c.CreateContactContext(context.Background(), contact)
+}
+
+// CreateContactContext is like CreateContact but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) CreateContactContext(ctx context.Context, contact Contact) (_ *Contact, err error) {
+	ctx, op := c.startOperation(ctx, "contacts.Create")
+	defer func() { op.end(err) }()
+
+	if err := c.ensureContactsInit(ctx); err != nil {
 		return nil, err
 	}
 
-	if c.contactsURL == "" || c.dsid == "" {
+	if !c.contactsReady() {
 		return nil, fmt.Errorf("contacts not available: missing contactsURL or dsid")
 	}
 
@@ -119,34 +131,16 @@ func (c *Client) CreateContact(contact Contact) (*Contact, error) {
 		Contacts: []Contact{contact},
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest(http.MethodPost, c.buildContactsURLWithTokens("contacts/card/"), bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, contactsCreate, http.MethodPost, func() (string, error) {
+		return c.buildContactsURLWithTokens("contacts/card/"), nil
+	}, reqBody)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 450 {
-		return nil, ErrSessionExpired
-	}
-
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("create contact failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, readAPIError(contactsCreate, resp)
 	}
 
 	var createResp ContactsResponse
@@ -154,12 +148,7 @@ func (c *Client) CreateContact(contact Contact) (*Contact, error) {
 		return nil, fmt.Errorf("decode create response: %w", err)
 	}
 
-	if createResp.SyncToken != "" {
-		c.syncToken = createResp.SyncToken
-	}
-	if createResp.PrefToken != "" {
-		c.prefToken = createResp.PrefToken
-	}
+	c.setContactsTokens(createResp.SyncToken, createResp.PrefToken)
 
 	if len(createResp.Contacts) == 0 {
 		return nil, fmt.Errorf("create contact: no contact returned in response")
@@ -171,6 +160,14 @@ func (c *Client) CreateContact(contact Contact) (*Contact, error) {
 // UpdateContact updates an existing contact. Requires ContactID and Etag to be set.
 // Returns the updated contact with new Etag.
 func (c *Client) UpdateContact(contact Contact) (*Contact, error) {
+	return c.UpdateContactContext(context.Background(), contact)
+}
+
+// UpdateContactContext is like UpdateContact but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) UpdateContactContext(ctx context.Context, contact Contact) (_ *Contact, err error) {
+	ctx, op := c.startOperation(ctx, "contacts.Update")
+	defer func() { op.end(err) }()
+
 	if contact.ContactID == "" {
 		return nil, fmt.Errorf("update contact: ContactID is required")
 	}
@@ -178,11 +175,11 @@ func (c *Client) UpdateContact(contact Contact) (*Contact, error) {
 		return nil, fmt.Errorf("update contact: Etag is required")
 	}
 
-	if err := c.ensureContactsInit(); err != nil {
+	if err := c.ensureContactsInit(ctx); err != nil {
 		return nil, err
 	}
 
-	if c.contactsURL == "" || c.dsid == "" {
+	if !c.contactsReady() {
 		return nil, fmt.Errorf("contacts not available: missing contactsURL or dsid")
 	}
 
@@ -192,38 +189,20 @@ func (c *Client) UpdateContact(contact Contact) (*Contact, error) {
 		Contacts: []Contact{contact},
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest(http.MethodPost, c.buildContactsURLWithTokens("contacts/card/")+"&method=PUT", bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, contactsUpdate, http.MethodPost, func() (string, error) {
+		return c.buildContactsURLWithTokens("contacts/card/") + "&method=PUT", nil
+	}, reqBody)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 450 {
-		return nil, ErrSessionExpired
-	}
-
-	if resp.StatusCode == 409 {
-		return nil, ErrContactEtagMismatch
-	}
-
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("update contact failed with status %d: %s", resp.StatusCode, string(body))
+		apiErr := readAPIError(contactsUpdate, resp)
+		if resp.StatusCode == 409 {
+			apiErr.Err = ErrContactEtagMismatch
+		}
+		return nil, apiErr
 	}
 
 	var updateResp ContactsResponse
@@ -231,12 +210,7 @@ func (c *Client) UpdateContact(contact Contact) (*Contact, error) {
 		return nil, fmt.Errorf("decode update response: %w", err)
 	}
 
-	if updateResp.SyncToken != "" {
-		c.syncToken = updateResp.SyncToken
-	}
-	if updateResp.PrefToken != "" {
-		c.prefToken = updateResp.PrefToken
-	}
+	c.setContactsTokens(updateResp.SyncToken, updateResp.PrefToken)
 
 	if len(updateResp.Contacts) == 0 {
 		return nil, fmt.Errorf("update contact: no contact returned in response")
@@ -246,6 +220,14 @@ func (c *Client) UpdateContact(contact Contact) (*Contact, error) {
 }
 
 func (c *Client) DeleteContact(contactID, etag string) error {
+	return c.DeleteContactContext(context.Background(), contactID, etag)
+}
+
+// DeleteContactContext is like DeleteContact but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) DeleteContactContext(ctx context.Context, contactID, etag string) (err error) {
+	ctx, op := c.startOperation(ctx, "contacts.Delete")
+	defer func() { op.end(err) }()
+
 	if contactID == "" {
 		return fmt.Errorf("delete contact: contactID is required")
 	}
@@ -253,11 +235,11 @@ func (c *Client) DeleteContact(contactID, etag string) error {
 		return fmt.Errorf("delete contact: etag is required")
 	}
 
-	if err := c.ensureContactsInit(); err != nil {
+	if err := c.ensureContactsInit(ctx); err != nil {
 		return err
 	}
 
-	if c.contactsURL == "" || c.dsid == "" {
+	if !c.contactsReady() {
 		return fmt.Errorf("contacts not available: missing contactsURL or dsid")
 	}
 
@@ -275,38 +257,20 @@ func (c *Client) DeleteContact(contactID, etag string) error {
 		},
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest(http.MethodPost, c.buildContactsURLWithTokens("contacts/card/")+"&method=DELETE", bytes.NewReader(data))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, contactsDelete, http.MethodPost, func() (string, error) {
+		return c.buildContactsURLWithTokens("contacts/card/") + "&method=DELETE", nil
+	}, reqBody)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 450 {
-		return ErrSessionExpired
-	}
-
-	if resp.StatusCode == 409 {
-		return ErrContactEtagMismatch
-	}
-
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("delete contact failed with status %d: %s", resp.StatusCode, string(body))
+		apiErr := readAPIError(contactsDelete, resp)
+		if resp.StatusCode == 409 {
+			apiErr.Err = ErrContactEtagMismatch
+		}
+		return apiErr
 	}
 
 	var deleteResp ContactsResponse
@@ -314,53 +278,35 @@ func (c *Client) DeleteContact(contactID, etag string) error {
 		return nil
 	}
 
-	if deleteResp.SyncToken != "" {
-		c.syncToken = deleteResp.SyncToken
-	}
-	if deleteResp.PrefToken != "" {
-		c.prefToken = deleteResp.PrefToken
-	}
+	c.setContactsTokens(deleteResp.SyncToken, deleteResp.PrefToken)
 
 	return nil
 }
 
 // ensureContactsInit calls reqStartup to initialize sync tokens if they haven't been set yet.
-func (c *Client) ensureContactsInit() error {
-	if c.syncToken == "" || c.prefToken == "" {
-		_, err := c.reqStartup()
+func (c *Client) ensureContactsInit(ctx context.Context) error {
+	if syncToken, prefToken := c.contactsTokens(); syncToken == "" || prefToken == "" {
+		_, err := c.reqStartup(ctx)
 		return err
 	}
 	return nil
 }
 
-func (c *Client) reqStartup() (*ContactsStartupResp, error) {
-	if c.contactsURL == "" || c.dsid == "" {
+func (c *Client) reqStartup(ctx context.Context) (*ContactsStartupResp, error) {
+	if !c.contactsReady() {
 		return nil, fmt.Errorf("contacts not available: missing contactsURL or dsid")
 	}
 
-	req, err := http.NewRequest(http.MethodGet, c.buildContactsURL("startup"), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, contactsStartup, http.MethodGet, func() (string, error) {
+		return c.buildContactsURL("startup"), nil
+	}, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 450 {
-		return nil, ErrSessionExpired
-	}
-
 	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("contacts startup failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, readAPIError(contactsStartup, resp)
 	}
 
 	var startupResp ContactsStartupResp
@@ -369,8 +315,7 @@ func (c *Client) reqStartup() (*ContactsStartupResp, error) {
 	}
 
 	// Store tokens for subsequent mutation requests.
-	c.syncToken = startupResp.SyncToken
-	c.prefToken = startupResp.PrefToken
+	c.setContactsTokens(startupResp.SyncToken, startupResp.PrefToken)
 
 	return &startupResp, nil
 }
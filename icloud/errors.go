@@ -0,0 +1,187 @@
+package icloud
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/tidwall/gjson"
+)
+
+// Service names reported in APIError.Service.
+const (
+	ServiceAuth     = "auth"
+	ServiceAccount  = "account"
+	ServiceHME      = "hme"
+	ServiceMail     = "mail"
+	ServiceFindMy   = "findmy"
+	ServiceContacts = "contacts"
+)
+
+// * APIError is returned when an Apple endpoint answers with an error status, or with a
+// success status but a body that reports failure. Use errors.As to inspect it:
+//
+//	var apiErr *icloud.APIError
+//	if errors.As(err, &apiErr) && apiErr.Retryable {
+//		// try again later
+//	}
+//
+// When the failure has a well known meaning, Err holds the matching sentinel (for example
+// ErrSessionExpired or ErrIncorrectUsernamePassword), so errors.Is keeps working.
+type APIError struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Service is the iCloud service that failed, one of the Service* constants.
+	Service string
+	// Endpoint names the call that failed, e.g. "hme.reserve".
+	Endpoint string
+
+	// Code, Title and Message describe the first error Apple reported in the body, if any.
+	Code    string
+	Title   string
+	Message string
+	// ServiceErrors holds every entry of Apple's serviceErrors list.
+	ServiceErrors []ServiceError
+
+	// Retryable hints that the same request may succeed later without any change, e.g.
+	// on rate limiting or a temporary server error.
+	Retryable bool
+
+	// Err is the sentinel error this failure maps to, or nil.
+	Err error
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+
+	b.WriteString(e.Endpoint)
+	b.WriteString(": ")
+
+	switch {
+	case e.Err != nil:
+		b.WriteString(e.Err.Error())
+	case e.StatusCode >= 200 && e.StatusCode < 300:
+		b.WriteString("request failed")
+	default:
+		fmt.Fprintf(&b, "unexpected status code: %d", e.StatusCode)
+	}
+
+	if msg := e.Message; msg != "" || e.Title != "" {
+		if msg == "" {
+			msg = e.Title
+		}
+		b.WriteString(": ")
+		b.WriteString(msg)
+	}
+
+	if e.Code != "" {
+		fmt.Fprintf(&b, " (code %s)", e.Code)
+	}
+
+	return b.String()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// codeAccountLocked is the serviceErrors code idmsa answers a sign in with when the Apple ID
+// has been locked for security reasons.
+const codeAccountLocked = "-20209"
+
+// hasCode reports whether Apple returned the given error code.
+func (e *APIError) hasCode(code string) bool {
+	if e.Code == code {
+		return true
+	}
+	for _, se := range e.ServiceErrors {
+		if se.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableStatus reports whether a status code is worth retrying as is.
+func retryableStatus(statusCode int) bool {
+	switch statusCode {
+	case 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// newAPIError builds the APIError for a failed call to ep from the response status and body.
+func newAPIError(ep endpoint, statusCode int, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		Service:    ep.service(),
+		Endpoint:   ep.String(),
+		Retryable:  retryableStatus(statusCode),
+	}
+
+	if sessionExpired(statusCode) {
+		apiErr.Err = ErrSessionExpired
+		if apiErr.Service == ServiceFindMy {
+			apiErr.Err = ErrFindMySessionExpired
+		}
+	}
+
+	parseServiceErrors(apiErr, body)
+
+	return apiErr
+}
+
+// readAPIError reads the response body and builds the APIError for a failed call to ep.
+func readAPIError(ep endpoint, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	return newAPIError(ep, resp.StatusCode, body)
+}
+
+// parseServiceErrors fills in the Apple error details from body. The services do not agree
+// on a format: idmsa and setup use a serviceErrors list, maildomainws nests an errorCode and
+// errorMessage under "error", the mail JSON-RPC endpoints use code and message, and the
+// setup endpoints sometimes answer with a plain reason.
+func parseServiceErrors(apiErr *APIError, body []byte) {
+	if !gjson.ValidBytes(body) {
+		return
+	}
+
+	res := gjson.ParseBytes(body)
+
+	for _, se := range res.Get("serviceErrors").Array() {
+		apiErr.ServiceErrors = append(apiErr.ServiceErrors, ServiceError{
+			Code:    se.Get("code").String(),
+			Title:   se.Get("title").String(),
+			Message: se.Get("message").String(),
+		})
+	}
+
+	if len(apiErr.ServiceErrors) > 0 {
+		first := apiErr.ServiceErrors[0]
+		apiErr.Code, apiErr.Title, apiErr.Message = first.Code, first.Title, first.Message
+		return
+	}
+
+	if e := res.Get("error"); e.IsObject() {
+		apiErr.Code = firstString(e, "errorCode", "code")
+		apiErr.Message = firstString(e, "errorMessage", "message")
+		return
+	}
+
+	apiErr.Code = firstString(res, "errorCode")
+	apiErr.Message = firstString(res, "errorMessage", "reason", "error")
+}
+
+// firstString returns the first non-empty value at one of paths.
+func firstString(res gjson.Result, paths ...string) string {
+	for _, path := range paths {
+		if v := res.Get(path); v.Exists() && v.Type != gjson.False && v.Type != gjson.True {
+			if s := v.String(); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,163 @@
+package icloud
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseServiceErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+
+		wantCode, wantTitle, wantMessage string
+		wantServiceErrors                []ServiceError
+	}{
+		{
+			name:              "idmsa serviceErrors",
+			body:              `{"serviceErrors":[{"code":"-20101","title":"Incorrect","message":"Your Apple ID or password was incorrect."},{"code":"-20209"}]}`,
+			wantCode:          "-20101",
+			wantTitle:         "Incorrect",
+			wantMessage:       "Your Apple ID or password was incorrect.",
+			wantServiceErrors: []ServiceError{{Code: "-20101", Title: "Incorrect", Message: "Your Apple ID or password was incorrect."}, {Code: "-20209"}},
+		},
+		{
+			name:        "maildomainws error object",
+			body:        `{"success":false,"error":{"errorCode":"-41015","errorMessage":"You have reached the limit of addresses."}}`,
+			wantCode:    "-41015",
+			wantMessage: "You have reached the limit of addresses.",
+		},
+		{
+			name:        "mail JSON-RPC error",
+			body:        `{"jsonrpc":"2.0","error":{"code":-32000,"message":"Message not found"}}`,
+			wantCode:    "-32000",
+			wantMessage: "Message not found",
+		},
+		{
+			name:        "top level errorCode",
+			body:        `{"errorCode":"CONTACT_NOT_FOUND","errorMessage":"The contact does not exist."}`,
+			wantCode:    "CONTACT_NOT_FOUND",
+			wantMessage: "The contact does not exist.",
+		},
+		{
+			name:        "setup reason",
+			body:        `{"success":false,"reason":"Missing X-APPLE-WEBAUTH-TOKEN cookie"}`,
+			wantMessage: "Missing X-APPLE-WEBAUTH-TOKEN cookie",
+		},
+		{
+			name:        "error string",
+			body:        `{"error":"Invalid request"}`,
+			wantMessage: "Invalid request",
+		},
+		{name: "error flag", body: `{"error":true}`},
+		{name: "empty object", body: `{}`},
+		{name: "html", body: `<html><body>Service Unavailable</body></html>`},
+		{name: "empty", body: ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var apiErr APIError
+			parseServiceErrors(&apiErr, []byte(tt.body))
+
+			if apiErr.Code != tt.wantCode || apiErr.Title != tt.wantTitle || apiErr.Message != tt.wantMessage {
+				t.Errorf("code, title, message = %q, %q, %q, want %q, %q, %q",
+					apiErr.Code, apiErr.Title, apiErr.Message, tt.wantCode, tt.wantTitle, tt.wantMessage)
+			}
+			if !reflect.DeepEqual(apiErr.ServiceErrors, tt.wantServiceErrors) {
+				t.Errorf("ServiceErrors = %+v, want %+v", apiErr.ServiceErrors, tt.wantServiceErrors)
+			}
+		})
+	}
+}
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name   string
+		ep     endpoint
+		status int
+		body   string
+
+		wantErr       error
+		wantService   string
+		wantRetryable bool
+		wantMessage   string
+	}{
+		{
+			name:        "session expired",
+			ep:          hmeList,
+			status:      450,
+			wantErr:     ErrSessionExpired,
+			wantService: ServiceHME,
+			wantMessage: "hme.list: " + ErrSessionExpired.Error(),
+		},
+		{
+			name:        "misdirected",
+			ep:          contactsList,
+			status:      421,
+			wantErr:     ErrSessionExpired,
+			wantService: ServiceContacts,
+			wantMessage: "contacts.list: " + ErrSessionExpired.Error(),
+		},
+		{
+			name:        "find my session expired",
+			ep:          fmRefreshClient,
+			status:      450,
+			wantErr:     ErrFindMySessionExpired,
+			wantService: ServiceFindMy,
+			wantMessage: "findmy.refreshClient: " + ErrFindMySessionExpired.Error(),
+		},
+		{
+			name:          "rate limited",
+			ep:            hmeReserve,
+			status:        429,
+			body:          `{"success":false,"error":{"errorCode":"-41015","errorMessage":"Too many requests"}}`,
+			wantService:   ServiceHME,
+			wantRetryable: true,
+			wantMessage:   "hme.reserve: unexpected status code: 429: Too many requests (code -41015)",
+		},
+		{
+			name:        "failure in a success status",
+			ep:          hmeDeactivate,
+			status:      200,
+			body:        `{"success":false,"reason":"not found"}`,
+			wantService: ServiceHME,
+			wantMessage: "hme.deactivate: request failed: not found",
+		},
+		{
+			name:        "title only",
+			ep:          authInit,
+			status:      400,
+			body:        `{"serviceErrors":[{"code":"-20000","title":"Bad request"}]}`,
+			wantService: ServiceAuth,
+			wantMessage: "auth.init: unexpected status code: 400: Bad request (code -20000)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := newAPIError(tt.ep, tt.status, []byte(tt.body))
+
+			var err error = apiErr
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.wantErr)
+			}
+			for _, sentinel := range []error{ErrSessionExpired, ErrFindMySessionExpired} {
+				if sentinel != tt.wantErr && errors.Is(err, sentinel) {
+					t.Errorf("errors.Is(%v, %v) = true", err, sentinel)
+				}
+			}
+
+			if apiErr.StatusCode != tt.status || apiErr.Service != tt.wantService || apiErr.Endpoint != tt.ep.String() {
+				t.Errorf("status, service, endpoint = %d, %q, %q", apiErr.StatusCode, apiErr.Service, apiErr.Endpoint)
+			}
+			if apiErr.Retryable != tt.wantRetryable {
+				t.Errorf("Retryable = %t, want %t", apiErr.Retryable, tt.wantRetryable)
+			}
+			if got := apiErr.Error(); got != tt.wantMessage {
+				t.Errorf("Error() = %q, want %q", got, tt.wantMessage)
+			}
+		})
+	}
+}
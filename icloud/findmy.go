@@ -2,6 +2,7 @@ package icloud
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,6 +18,9 @@ const (
 	fmAPIVersion        = "3.0"
 )
 
+// errNoServerContext is returned by PlaySound before GetDevices has been called.
+var errNoServerContext = errors.New("server context unavailable: call GetDevices() before PlaySound()")
+
 type fmClientContext struct {
 	AppName           string `json:"appName"`
 	AppVersion        string `json:"appVersion"`
@@ -44,7 +48,15 @@ type fmPlaySoundReq struct {
 // GetDevices fetches the full list of Find My devices for the account (including family members).
 // It must be called at least once before PlaySound to initialize the server context.
 func (c *Client) GetDevices() ([]FMDevice, error) {
-	resp, err := c.reqRefreshClient()
+	return c.GetDevicesContext(context.Background())
+}
+
+// GetDevicesContext is like GetDevices but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) GetDevicesContext(ctx context.Context) (_ []FMDevice, err error) {
+	ctx, op := c.startOperation(ctx, "findmy.RefreshClient")
+	defer func() { op.end(err) }()
+
+	resp, err := c.reqRefreshClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +66,15 @@ func (c *Client) GetDevices() ([]FMDevice, error) {
 // GetDevice fetches all devices and returns the one matching the given ID.
 // Returns an error if the device is not found.
 func (c *Client) GetDevice(id string) (*FMDevice, error) {
-	devices, err := c.GetDevices()
+	return c.GetDeviceContext(context.Background(), id)
+}
+
+// GetDeviceContext is like GetDevice but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) GetDeviceContext(ctx context.Context, id string) (_ *FMDevice, err error) {
+	ctx, op := c.startOperation(ctx, "findmy.GetDevice")
+	defer func() { op.end(err) }()
+
+	devices, err := c.GetDevicesContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -75,17 +95,33 @@ func (c *Client) GetDevice(id string) (*FMDevice, error) {
 // GetDevices must be called at least once before PlaySound to establish the server context.
 // Returns the updated device state as reported by the server.
 func (c *Client) PlaySound(deviceID string, channels []string) (*FMDevice, error) {
-	return c.reqPlaySound(deviceID, channels)
+	return c.PlaySoundContext(context.Background(), deviceID, channels)
+}
+
+// PlaySoundContext is like PlaySound but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) PlaySoundContext(ctx context.Context, deviceID string, channels []string) (_ *FMDevice, err error) {
+	ctx, op := c.startOperation(ctx, "findmy.PlaySound")
+	defer func() { op.end(err) }()
+
+	return c.reqPlaySound(ctx, deviceID, channels)
 }
 
 // fmipURL builds the full URL for a fmipservice action including required query parameters.
 func (c *Client) fmipURL(action string) string {
+	frameID, dsid := c.accountIDs()
+
 	return fmt.Sprintf(
 		"%s/fmipservice/client/web/%s?clientBuildNumber=%s&clientMasteringNumber=%s&clientId=%s&dsid=%s",
-		c.findMeURL, action, fmClientBuildNumber, fmClientBuildNumber, c.frameId, c.dsid,
+		c.webserviceURL(wsFindMe), action, fmClientBuildNumber, fmClientBuildNumber, frameID, dsid,
 	)
 }
 
+// findMyReady reports whether the account's Find My service is known.
+func (c *Client) findMyReady() bool {
+	_, dsid := c.accountIDs()
+	return c.webserviceURL(wsFindMe) != "" && dsid != ""
+}
+
 func (c *Client) defaultClientContext() fmClientContext {
 	return fmClientContext{
 		AppName:           fmAppName,
@@ -93,67 +129,100 @@ func (c *Client) defaultClientContext() fmClientContext {
 		APIVersion:        fmAPIVersion,
 		DeviceListVersion: 1,
 		Fmly:              true,
-		Timezone:          "US/Pacific",
+		Timezone:          c.timezone,
 		InactiveTime:      0,
 	}
 }
 
-// serverContextWithID returns the stored server context with "id": "server_ctx" injected,
+// serverContextWithID returns the server context with "id": "server_ctx" injected,
 // ready to be echoed back to the fmipservice API.
-func (c *Client) serverContextWithID() (json.RawMessage, error) {
+func serverContextWithID(serverCtx json.RawMessage) (json.RawMessage, error) {
 	var ctxMap map[string]interface{}
-	if err := json.Unmarshal(c.fmServerCtx, &ctxMap); err != nil {
+	if err := json.Unmarshal(serverCtx, &ctxMap); err != nil {
 		return nil, fmt.Errorf("unmarshal server context: %w", err)
 	}
 	ctxMap["id"] = "server_ctx"
 	return json.Marshal(ctxMap)
 }
 
-func (c *Client) reqRefreshClient() (*FMDevicesResp, error) {
-	if c.findMeURL == "" || c.dsid == "" {
-		return nil, errors.New("find my not initialized: call Login() first")
-	}
-
+// refreshClientBody returns the refreshClient request, echoing back the current server
+// context if there is one.
+func (c *Client) refreshClientBody() ([]byte, error) {
 	reqBody := fmRefreshClientReq{
 		ClientContext: c.defaultClientContext(),
 	}
 
-	// Echo back the previous server context if we have one.
-	if c.fmServerCtx != nil && string(c.fmServerCtx) != "null" {
-		ctx, err := c.serverContextWithID()
+	if prev := c.fmServerContext(); prev != nil && string(prev) != "null" {
+		serverCtx, err := serverContextWithID(prev)
 		if err != nil {
 			return nil, err
 		}
-		reqBody.ServerContext = ctx
+		reqBody.ServerContext = serverCtx
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
+	return json.Marshal(reqBody)
+}
+
+// playSoundBody returns the playSound request for the device with the current server
+// context, which GetDevices must have set.
+func (c *Client) playSoundBody(deviceID string, channels []string) ([]byte, error) {
+	prev := c.fmServerContext()
+	if prev == nil || string(prev) == "null" {
+		return nil, errNoServerContext
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.fmipURL("refreshClient"), bytes.NewReader(data))
+	serverCtx, err := serverContextWithID(prev)
 	if err != nil {
 		return nil, err
 	}
 
-	// fmipservice requires text/plain despite the body being JSON.
-	req.Header.Set(HdrContentType, "text/plain;charset=UTF-8")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set("accept", "application/json")
+	reqBody := fmPlaySoundReq{
+		ServerContext: serverCtx,
+		ClientContext: c.defaultClientContext(),
+		Device:        deviceID,
+		UserAction:    "PlaySound",
+		Channels:      channels,
+	}
+	// The subject field is only sent for multi-channel accessories (AirPods).
+	if len(channels) > 0 {
+		reqBody.Subject = "Find My Accessory Alert"
+	}
+
+	return json.Marshal(reqBody)
+}
 
-	resp, err := c.HttpClient.Do(req)
+func (c *Client) reqRefreshClient(ctx context.Context) (*FMDevicesResp, error) {
+	if !c.findMyReady() {
+		return nil, errors.New("find my not initialized: call Login() first")
+	}
+
+	resp, err := c.send(ctx, fmRefreshClient, func() (*http.Request, error) {
+		// the server context is read for every attempt, so a replay echoes the latest one
+		data, err := c.refreshClientBody()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.fmipURL("refreshClient"), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		// fmipservice requires text/plain despite the body being JSON.
+		req.Header.Set(HdrContentType, "text/plain;charset=UTF-8")
+		req.Header.Set("origin", c.currentRegion().HomeOrigin)
+		req.Header.Set("referer", c.currentRegion().HomeOrigin+"/")
+		req.Header.Set("accept", "application/json")
+
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 450 {
-		return nil, ErrFindMySessionExpired
-	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, readAPIError(fmRefreshClient, resp)
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -168,63 +237,45 @@ func (c *Client) reqRefreshClient() (*FMDevicesResp, error) {
 
 	// Cache the server context; it must be echoed back on all subsequent fmip calls.
 	if devicesResp.ServerContext != nil && string(devicesResp.ServerContext) != "null" {
-		c.fmServerCtx = devicesResp.ServerContext
+		c.setFMServerContext(devicesResp.ServerContext)
 	}
 
 	return &devicesResp, nil
 }
 
-func (c *Client) reqPlaySound(deviceID string, channels []string) (*FMDevice, error) {
-	if c.findMeURL == "" || c.dsid == "" {
+func (c *Client) reqPlaySound(ctx context.Context, deviceID string, channels []string) (*FMDevice, error) {
+	if !c.findMyReady() {
 		return nil, errors.New("find my not initialized: call Login() first")
 	}
-	if c.fmServerCtx == nil || string(c.fmServerCtx) == "null" {
-		return nil, errors.New("server context unavailable: call GetDevices() before PlaySound()")
-	}
-
-	ctx, err := c.serverContextWithID()
-	if err != nil {
-		return nil, err
-	}
-
-	reqBody := fmPlaySoundReq{
-		ServerContext: ctx,
-		ClientContext: c.defaultClientContext(),
-		Device:        deviceID,
-		UserAction:    "PlaySound",
-		Channels:      channels,
-	}
-	// The subject field is only sent for multi-channel accessories (AirPods).
-	if len(channels) > 0 {
-		reqBody.Subject = "Find My Accessory Alert"
+	if prev := c.fmServerContext(); prev == nil || string(prev) == "null" {
+		return nil, errNoServerContext
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
-	}
+	resp, err := c.send(ctx, fmPlaySound, func() (*http.Request, error) {
+		data, err := c.playSoundBody(deviceID, channels)
+		if err != nil {
+			return nil, err
+		}
 
-	req, err := http.NewRequest(http.MethodPost, c.fmipURL("playSound"), bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.fmipURL("playSound"), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
 
-	req.Header.Set(HdrContentType, "text/plain;charset=UTF-8")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("referer", "https://www.icloud.com/")
-	req.Header.Set("accept", "application/json")
+		req.Header.Set(HdrContentType, "text/plain;charset=UTF-8")
+		req.Header.Set("origin", c.currentRegion().HomeOrigin)
+		req.Header.Set("referer", c.currentRegion().HomeOrigin+"/")
+		req.Header.Set("accept", "application/json")
 
-	resp, err := c.HttpClient.Do(req)
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 450 {
-		return nil, ErrFindMySessionExpired
-	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, readAPIError(fmPlaySound, resp)
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
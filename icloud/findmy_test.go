@@ -0,0 +1,104 @@
+package icloud
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// funcTransport answers requests with a function.
+type funcTransport func(req *http.Request) (*http.Response, error)
+
+func (f funcTransport) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (f funcTransport) GetCookies(u *url.URL) []*http.Cookie { return nil }
+
+func (f funcTransport) SetCookies(u *url.URL, cookies []*http.Cookie) {}
+
+func jsonResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// TestFindMyReplayEchoesCurrentServerContext checks that a replayed Find My request is
+// built again, so it carries the server context as it is when it is sent, not as it was
+// for the first attempt. Retries and AutoReauth replays both go through the build func.
+func TestFindMyReplayEchoesCurrentServerContext(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(c *Client) error
+	}{
+		{"refreshClient", func(c *Client) error {
+			_, err := c.GetDevices()
+			return err
+		}},
+		{"playSound", func(c *Client) error {
+			_, err := c.PlaySound("device-1", nil)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c *Client
+			var sent []string
+
+			transport := funcTransport(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					ServerContext struct {
+						ServerTimestamp int64 `json:"serverTimestamp"`
+					} `json:"serverContext"`
+				}
+				data, _ := io.ReadAll(req.Body)
+				if err := json.Unmarshal(data, &body); err != nil {
+					t.Errorf("request body: %v", err)
+				}
+				sent = append(sent, string(data))
+
+				if len(sent) == 1 {
+					// a concurrent GetDevices refreshes the context before the replay
+					c.setFMServerContext(json.RawMessage(`{"serverTimestamp":2}`))
+					return jsonResponse(req, 503, "{}"), nil
+				}
+				if body.ServerContext.ServerTimestamp != 2 {
+					t.Errorf("the replay echoes server context %d, want the current one", body.ServerContext.ServerTimestamp)
+				}
+				return jsonResponse(req, 200, `{"serverContext":{"serverTimestamp":3},"content":[{"id":"device-1"}]}`), nil
+			})
+
+			var err error
+			c, err = NewClientWithOptions(
+				WithCredentials("user@icloud.com", "password"),
+				WithTransport(transport),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, RetryMutations: true}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.dsid = "123456789"
+			c.webservices = map[string]Webservice{wsFindMe: {URL: "https://p01-fmipweb.icloud.com"}}
+			c.setFMServerContext(json.RawMessage(`{"serverTimestamp":1}`))
+
+			if err := tt.call(c); err != nil {
+				t.Fatal(err)
+			}
+			if len(sent) != 2 {
+				t.Fatalf("sent %d requests, want the first attempt and its replay", len(sent))
+			}
+			if !strings.Contains(sent[0], `"serverTimestamp":1`) {
+				t.Errorf("the first attempt sent %s, want the initial server context", sent[0])
+			}
+		})
+	}
+}
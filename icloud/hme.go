@@ -1,10 +1,8 @@
 package icloud
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io"
 	"strings"
 
@@ -14,7 +12,15 @@ import (
 
 // * RetrieveHMEList() Retrieves a list of HMEs from the user's account
 func (c *Client) RetrieveHMEList() ([]HmeEmail, error) {
-	body, err := c.reqRetrieveHMEList()
+	return c.RetrieveHMEListContext(context.Background())
+}
+
+// RetrieveHMEListContext is like RetrieveHMEList but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) RetrieveHMEListContext(ctx context.Context) (_ []HmeEmail, err error) {
+	ctx, op := c.startOperation(ctx, "hme.List")
+	defer func() { op.end(err) }()
+
+	body, err := c.reqRetrieveHMEList(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -30,60 +36,80 @@ func (c *Client) RetrieveHMEList() ([]HmeEmail, error) {
 
 // * ReserveHME() Generates a new HME and reserves it, if successful it returns the email to user
 func (c *Client) ReserveHME(label, note string) (string, error) {
-	hme, err := c.reqGenerateHME()
+	return c.ReserveHMEContext(context.Background(), label, note)
+}
+
+// ReserveHMEContext is like ReserveHME but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) ReserveHMEContext(ctx context.Context, label, note string) (_ string, err error) {
+	ctx, op := c.startOperation(ctx, "hme.Reserve")
+	defer func() { op.end(err) }()
+
+	hme, err := c.reqGenerateHME(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	return c.reqReserveHME(hme, label, note)
+	return c.reqReserveHME(ctx, hme, label, note)
 }
 
-// * DeactivateHME() Deactivates an HME from the user's account, using the given anonymousId. Can be reactivated later
+// * DeactivateHME() Deactivates an HME from the user's account, using the given anonymousId. Can be reactivated later. If Apple refuses the request an *APIError is returned
 func (c *Client) DeactivateHME(anonymousId string) (bool, error) {
-	body, err := c.reqDeactivateHME(anonymousId)
-	if err != nil {
+	return c.DeactivateHMEContext(context.Background(), anonymousId)
+}
+
+// DeactivateHMEContext is like DeactivateHME but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) DeactivateHMEContext(ctx context.Context, anonymousId string) (_ bool, err error) {
+	ctx, op := c.startOperation(ctx, "hme.Deactivate")
+	defer func() { op.end(err) }()
+
+	if _, err := c.reqDeactivateHME(ctx, anonymousId); err != nil {
 		return false, err
 	}
 
-	return gjson.Get(body, "success").Bool(), nil
+	return true, nil
 }
 
 // * ReactivateHME() Reactivates an HME for a user's account, using the given anonymousId
 func (c *Client) ReactivateHME(anonymousId string) (bool, error) {
-	body, err := c.reqReactivateHME(anonymousId)
-	if err != nil {
+	return c.ReactivateHMEContext(context.Background(), anonymousId)
+}
+
+// ReactivateHMEContext is like ReactivateHME but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) ReactivateHMEContext(ctx context.Context, anonymousId string) (_ bool, err error) {
+	ctx, op := c.startOperation(ctx, "hme.Reactivate")
+	defer func() { op.end(err) }()
+
+	if _, err := c.reqReactivateHME(ctx, anonymousId); err != nil {
 		return false, err
 	}
 
-	return gjson.Get(body, "success").Bool(), nil
+	return true, nil
 }
 
 // * DeleteHME() Deletes an HME from the user's account, using the given anonymousId. Can not be recovered later
 func (c *Client) DeleteHME(anonymousId string) (bool, error) {
-	body, err := c.reqDeleteHME(anonymousId)
-	if err != nil {
+	return c.DeleteHMEContext(context.Background(), anonymousId)
+}
+
+// DeleteHMEContext is like DeleteHME but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) DeleteHMEContext(ctx context.Context, anonymousId string) (_ bool, err error) {
+	ctx, op := c.startOperation(ctx, "hme.Delete")
+	defer func() { op.end(err) }()
+
+	if _, err := c.reqDeleteHME(ctx, anonymousId); err != nil {
 		return false, err
 	}
 
-	return gjson.Get(body, "success").Bool(), nil
+	return true, nil
 }
 
-func (c *Client) reqRetrieveHMEList() (string, error) {
-	req, err := http.NewRequest(http.MethodGet, endpoints[hmeList], nil)
+func (c *Client) reqRetrieveHMEList(ctx context.Context) (string, error) {
+	resp, err := c.sendJSON(ctx, hmeList, http.MethodGet, nil, nil)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readHMEResponse(hmeList, resp)
 	if err != nil {
 		return "", err
 	}
@@ -91,85 +117,53 @@ func (c *Client) reqRetrieveHMEList() (string, error) {
 	return string(bodyBytes), nil
 }
 
-func (c *Client) reqGenerateHME() (string, error) {
-	body := bytes.NewReader([]byte(`{"langCode":"en-us"}`))
+func (c *Client) reqGenerateHME(ctx context.Context) (string, error) {
+	data := map[string]string{"langCode": c.langCode()}
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[hmeGen], body)
+	resp, err := c.sendJSON(ctx, hmeGen, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readHMEResponse(hmeGen, resp)
 	if err != nil {
 		return "", err
 	}
 
-	success := gjson.Get(string(bodyBytes), "success").Bool()
-	if !success {
-		return "", errors.New("failed to generate HME")
-	}
-
-	email := gjson.Get(string(bodyBytes), "result.hme").String()
+	email := gjson.GetBytes(bodyBytes, "result.hme").String()
 
 	return email, nil
 }
 
-func (c *Client) reqReserveHME(email, label, note string) (string, error) {
-	body := bytes.NewReader([]byte(fmt.Sprintf(`{"hme":"%s","label":"%s","note":"%s"}`, email, label, note)))
-
-	req, err := http.NewRequest(http.MethodPost, endpoints[hmeReserve], body)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
+func (c *Client) reqReserveHME(ctx context.Context, email, label, note string) (string, error) {
+	data := map[string]string{"hme": email, "label": label, "note": note}
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, hmeReserve, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readHMEResponse(hmeReserve, resp)
 	if err != nil {
 		return "", err
 	}
 
 	if !strings.Contains(string(bodyBytes), email) {
-		return "", errors.New("failed to reserve HME")
+		return "", newAPIError(hmeReserve, resp.StatusCode, bodyBytes)
 	}
 
 	return email, nil
 }
 
-func (c *Client) reqDeactivateHME(anonymousId string) (string, error) {
-	body := bytes.NewReader([]byte(`{"anonymousId":"` + anonymousId + `"}`))
+func (c *Client) reqDeactivateHME(ctx context.Context, anonymousId string) (string, error) {
+	data := map[string]string{"anonymousId": anonymousId}
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[hmeDeactivate], body)
+	resp, err := c.sendJSON(ctx, hmeDeactivate, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readHMEResponse(hmeDeactivate, resp)
 	if err != nil {
 		return "", err
 	}
@@ -177,24 +171,15 @@ func (c *Client) reqDeactivateHME(anonymousId string) (string, error) {
 	return string(bodyBytes), nil
 }
 
-func (c *Client) reqReactivateHME(anonymousId string) (string, error) {
-	body := bytes.NewReader([]byte(`{"anonymousId":"` + anonymousId + `"}`))
-
-	req, err := http.NewRequest(http.MethodPost, endpoints[hmeReactivate], body)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
+func (c *Client) reqReactivateHME(ctx context.Context, anonymousId string) (string, error) {
+	data := map[string]string{"anonymousId": anonymousId}
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, hmeReactivate, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readHMEResponse(hmeReactivate, resp)
 	if err != nil {
 		return "", err
 	}
@@ -202,27 +187,35 @@ func (c *Client) reqReactivateHME(anonymousId string) (string, error) {
 	return string(bodyBytes), nil
 }
 
-func (c *Client) reqDeleteHME(anonymousId string) (string, error) {
-	body := bytes.NewReader([]byte(`{"anonymousId":"` + anonymousId + `"}`))
+func (c *Client) reqDeleteHME(ctx context.Context, anonymousId string) (string, error) {
+	data := map[string]string{"anonymousId": anonymousId}
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[hmeDelete], body)
+	resp, err := c.sendJSON(ctx, hmeDelete, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
+	bodyBytes, err := readHMEResponse(hmeDelete, resp)
 	if err != nil {
 		return "", err
 	}
 
+	return string(bodyBytes), nil
+}
+
+// readHMEResponse reads a maildomainws response. The service reports failures with a
+// "success": false body, often alongside a 200 status, so both are checked.
+func readHMEResponse(ep endpoint, resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(bodyBytes), nil
+	if resp.StatusCode != 200 || !gjson.GetBytes(bodyBytes, "success").Bool() {
+		return nil, newAPIError(ep, resp.StatusCode, bodyBytes)
+	}
+
+	return bodyBytes, nil
 }
@@ -0,0 +1,368 @@
+package icloudtest
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/internal/srp"
+)
+
+// Apple's serviceErrors codes for failed sign ins.
+const (
+	codeIncorrectCredentials = "-20101"
+	codeAccountLocked        = "-20209"
+	codeIncorrectCode        = "-21669"
+)
+
+func (s *Server) handleAuthStart(w http.ResponseWriter, r *http.Request, body []byte) {
+	w.Header().Set("X-Apple-Auth-Attributes", randomHex(16))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleFederate(w http.ResponseWriter, r *http.Request, body []byte) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hasSWP": false,
+	})
+}
+
+func (s *Server) handleSigninInit(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req icloud.AuthInitReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeServiceError(w, http.StatusBadRequest, "-20000", err.Error())
+		return
+	}
+
+	a, ok := s.accounts[accountKey(req.AccountName)]
+	if !ok {
+		writeServiceError(w, http.StatusUnauthorized, codeIncorrectCredentials, "Your Apple ID or password was incorrect.")
+		return
+	}
+
+	A, err := b64.StdEncoding.DecodeString(req.A)
+	if err != nil {
+		writeServiceError(w, http.StatusBadRequest, "-20000", "invalid a")
+		return
+	}
+
+	server := srp.NewSRPServer(srp.AppleParams(), a.verifier, nil)
+	c := randomHex(16)
+	s.signins[c] = &signin{acct: a, srp: server, a: A}
+
+	writeJSON(w, http.StatusOK, icloud.AuthInitResp{
+		Iteration: a.Iterations,
+		Salt:      b64.StdEncoding.EncodeToString(a.salt),
+		Protocol:  a.Protocol,
+		B:         b64.StdEncoding.EncodeToString(server.GetBBytes()),
+		C:         c,
+	})
+}
+
+func (s *Server) handleSigninComplete(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req icloud.AuthCompleteReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeServiceError(w, http.StatusBadRequest, "-20000", err.Error())
+		return
+	}
+
+	in, ok := s.signins[req.C]
+	if !ok || accountKey(req.AccountName) != accountKey(in.acct.AppleID) {
+		writeServiceError(w, http.StatusUnauthorized, codeIncorrectCredentials, "Your Apple ID or password was incorrect.")
+		return
+	}
+	delete(s.signins, req.C)
+
+	a := in.acct
+
+	if a.Locked {
+		writeServiceError(w, http.StatusForbidden, codeAccountLocked, "This Apple ID has been locked for security reasons.")
+		return
+	}
+
+	M1, err := b64.StdEncoding.DecodeString(req.M1)
+	if err != nil {
+		writeServiceError(w, http.StatusBadRequest, "-20000", "invalid m1")
+		return
+	}
+
+	// the client signs in with the Apple ID as typed, and so must the proof
+	M2, err := in.srp.ProcessClientProof([]byte(req.AccountName), a.salt, in.a, M1)
+	if err != nil {
+		writeServiceError(w, http.StatusForbidden, codeIncorrectCredentials, "Your Apple ID or password was incorrect.")
+		return
+	}
+
+	resp := icloud.AuthCompleteResp{M2: b64.StdEncoding.EncodeToString(M2)}
+	switch {
+	case a.OmitServerProof:
+		resp.M2 = ""
+	case a.WrongServerProof:
+		M2[0] ^= 0xff
+		resp.M2 = b64.StdEncoding.EncodeToString(M2)
+	}
+
+	if a.TwoFactor && !s.trusted(a, req.TrustTokens) {
+		sessionID := randomHex(16)
+		s.challenges[sessionID] = a
+
+		w.Header().Set(icloud.HdrXAppleIDSessionID, sessionID)
+		w.Header().Set(icloud.HdrScnt, randomHex(16))
+
+		resp.AuthType = "hsa2"
+		writeJSON(w, http.StatusConflict, resp)
+		return
+	}
+
+	token := randomHex(32)
+	s.sessionTokens[token] = a
+	w.Header().Set("X-Apple-Session-Token", token)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// trusted reports whether one of tokens is a trust token of the account. The caller must
+// hold s.mu.
+func (s *Server) trusted(a *account, tokens []string) bool {
+	for _, token := range tokens {
+		if s.trustTokens[token] == a {
+			return true
+		}
+	}
+	return false
+}
+
+// challenge returns the id and account of the two-factor challenge r belongs to. If there
+// is none it answers with an error and returns a nil account. The caller must hold s.mu.
+func (s *Server) challenge(w http.ResponseWriter, r *http.Request) (string, *account) {
+	sessionID := r.Header.Get(icloud.HdrXAppleIDSessionID)
+
+	a, ok := s.challenges[sessionID]
+	if !ok || r.Header.Get(icloud.HdrScnt) == "" {
+		writeServiceError(w, http.StatusUnauthorized, "-20000", "No sign in is in progress.")
+		return "", nil
+	}
+
+	w.Header().Set(icloud.HdrScnt, randomHex(16))
+
+	return sessionID, a
+}
+
+func (s *Server) handleAuthOptions(w http.ResponseWriter, r *http.Request, body []byte) {
+	_, a := s.challenge(w, r)
+	if a == nil {
+		return
+	}
+
+	trustedDevices := 1
+	if a.NoTrustedDevices {
+		trustedDevices = 0
+	}
+
+	writeJSON(w, http.StatusOK, icloud.AuthOptionsResp{
+		TrustedPhoneNumbers: a.TrustedPhones,
+		TrustedPhoneNumber:  &a.TrustedPhones[0],
+		SecurityCode:        icloud.SecurityCodeOptions{Length: len(a.Code)},
+		AuthenticationType:  "hsa2",
+		TrustedDeviceCount:  trustedDevices,
+		NoTrustedDevices:    a.NoTrustedDevices,
+	})
+}
+
+func (s *Server) handleRequestPhoneCode(w http.ResponseWriter, r *http.Request, body []byte) {
+	_, a := s.challenge(w, r)
+	if a == nil {
+		return
+	}
+
+	var req icloud.TwoFactorCodeFromPhoneRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.PhoneNumber == nil || !hasPhone(a, req.PhoneNumber.ID) {
+		writeServiceError(w, http.StatusBadRequest, "-21675", "The phone number is not a trusted number.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"trustedPhoneNumber": a.TrustedPhones[0],
+		"mode":               req.Mode,
+	})
+}
+
+// handleSecurityCode answers both the PUT that pushes a code to the trusted devices and
+// the POST that submits a code.
+func (s *Server) handleSecurityCode(w http.ResponseWriter, r *http.Request, body []byte) {
+	sessionID, a := s.challenge(w, r)
+	if a == nil {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req icloud.TwoFactorCodeFromPhoneRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.SecurityCode == nil || req.SecurityCode.Code != a.Code {
+		writeServiceError(w, http.StatusBadRequest, codeIncorrectCode, "Incorrect verification code.")
+		return
+	}
+
+	if req.PhoneNumber != nil && !hasPhone(a, req.PhoneNumber.ID) {
+		writeServiceError(w, http.StatusBadRequest, "-21675", "The phone number is not a trusted number.")
+		return
+	}
+
+	s.verified[sessionID] = true
+
+	// trusted device codes are accepted with 204, phone codes with 200
+	if req.PhoneNumber == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (s *Server) handleTrust(w http.ResponseWriter, r *http.Request, body []byte) {
+	sessionID, a := s.challenge(w, r)
+	if a == nil {
+		return
+	}
+
+	if !s.verified[sessionID] {
+		writeServiceError(w, http.StatusUnauthorized, "-20000", "The verification code has not been entered.")
+		return
+	}
+	delete(s.verified, sessionID)
+	delete(s.challenges, sessionID)
+
+	token, trust := randomHex(32), randomHex(32)
+	s.sessionTokens[token] = a
+	s.trustTokens[trust] = a
+
+	w.Header().Set("X-Apple-Session-Token", token)
+	w.Header().Set("X-Apple-TwoSV-Trust-Token", trust)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func hasPhone(a *account, id int) bool {
+	for _, phone := range a.TrustedPhones {
+		if phone.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAccountLogin exchanges a session token for an iCloud web session, both for the
+// first accountLogin and for the partition login that follows it.
+func (s *Server) handleAccountLogin(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req struct {
+		DsWebAuthToken string `json:"dsWebAuthToken"`
+	}
+	json.Unmarshal(body, &req)
+
+	a, ok := s.sessionTokens[req.DsWebAuthToken]
+	if !ok {
+		writeJSON(w, http.StatusMisdirectedRequest, map[string]interface{}{
+			"success": false,
+			"error":   "Invalid global session",
+		})
+		return
+	}
+
+	session := randomHex(32)
+	s.webSessions[session] = a
+	http.SetCookie(w, &http.Cookie{Name: webAuthCookie, Value: session, Path: "/", HttpOnly: true})
+
+	webservices := make(map[string]icloud.Webservice)
+	for _, name := range []string{"account", "findme", "contacts", "mail", "mccgateway", "premiummailsettings"} {
+		webservices[name] = icloud.Webservice{URL: s.URL, Status: "active"}
+	}
+	if !a.ICloudPlus {
+		webservices["premiummailsettings"] = icloud.Webservice{URL: s.URL, Status: "inactive"}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dsInfo":          s.dsInfo(a),
+		"webservices":     webservices,
+		"isExtendedLogin": true,
+	})
+}
+
+func (s *Server) dsInfo(a *account) icloud.AccountInfo {
+	return icloud.AccountInfo{
+		Dsid:                 a.Dsid,
+		FullName:             a.FirstName + " " + a.LastName,
+		FirstName:            a.FirstName,
+		LastName:             a.LastName,
+		AppleID:              a.AppleID,
+		AppleIDAliases:       []string{},
+		PrimaryEmail:         a.AppleID,
+		CountryCode:          "USA",
+		Locale:               "en_US",
+		LanguageCode:         "en-us",
+		ICloudPlus:           a.ICloudPlus,
+		HideMyEmailAvailable: true,
+	}
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.sessionAccount(r)
+	if a == nil {
+		writeJSON(w, http.StatusMisdirectedRequest, map[string]interface{}{
+			"success": false,
+			"error":   "Missing X-APPLE-WEBAUTH-TOKEN cookie",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"dsInfo":  s.dsInfo(a),
+	})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request, body []byte) {
+	if cookie, err := r.Cookie(webAuthCookie); err == nil {
+		delete(s.webSessions, cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: webAuthCookie, Value: "", Path: "/", Expires: time.Unix(0, 0)})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+func (s *Server) handleStorage(w http.ResponseWriter, r *http.Request, body []byte) {
+	if s.sessionAccount(r) == nil {
+		writeSessionExpired(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, icloud.StorageUsage{
+		Info: icloud.StorageUsageInfo{
+			UsedBytes:  1 << 30,
+			TotalBytes: 5 << 30,
+		},
+		ByMedia: []icloud.StorageMediaUsage{
+			{MediaKey: "mail", DisplayLabel: "Mail", DisplayColor: "3478f6", UsageBytes: 1 << 30},
+		},
+	})
+}
+
+func (s *Server) handleFamily(w http.ResponseWriter, r *http.Request, body []byte) {
+	if s.sessionAccount(r) == nil {
+		writeSessionExpired(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"isMemberOfFamily": false,
+	})
+}
+
+// writeSessionExpired answers a service call made without a valid web session.
+func writeSessionExpired(w http.ResponseWriter) {
+	writeJSON(w, 450, map[string]interface{}{
+		"success": false,
+		"reason":  "Authentication required",
+	})
+}
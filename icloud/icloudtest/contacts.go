@@ -0,0 +1,172 @@
+package icloudtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+)
+
+// contactsAccount returns the account of a contacts call, or nil after answering with an
+// error. Calls other than startup must carry the tokens startup handed out. The caller must
+// hold s.mu.
+func (s *Server) contactsAccount(w http.ResponseWriter, r *http.Request, tokens bool) *account {
+	a := s.sessionAccount(r)
+	if a == nil {
+		writeSessionExpired(w)
+		return nil
+	}
+
+	if tokens && (r.URL.Query().Get("syncToken") == "" || r.URL.Query().Get("prefToken") == "") {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errorCode": "MISSING_TOKEN", "errorMessage": "syncToken and prefToken are required"})
+		return nil
+	}
+
+	return a
+}
+
+// writeContacts answers with contacts and the account's current tokens.
+func writeContacts(w http.ResponseWriter, a *account, contacts []icloud.Contact) {
+	writeJSON(w, http.StatusOK, icloud.ContactsResponse{
+		Contacts:  contacts,
+		SyncToken: syncToken(a),
+		PrefToken: prefToken(a),
+	})
+}
+
+func syncToken(a *account) string {
+	return fmt.Sprintf("HwoQEgwAA%d", a.syncToken)
+}
+
+func prefToken(a *account) string {
+	return "4C9B1D3E" + a.Dsid
+}
+
+func (s *Server) handleContactsStartup(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.contactsAccount(w, r, false)
+	if a == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, icloud.ContactsStartupResp{
+		Contacts:      append([]icloud.Contact{}, a.Contacts...),
+		SyncToken:     syncToken(a),
+		PrefToken:     prefToken(a),
+		ContactsOrder: []string{"last", "first"},
+	})
+}
+
+func (s *Server) handleContactsList(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.contactsAccount(w, r, true)
+	if a == nil {
+		return
+	}
+
+	writeContacts(w, a, append([]icloud.Contact{}, a.Contacts...))
+}
+
+// readContacts decodes the contacts of a card request, or answers with an error and
+// returns false.
+func readContacts(w http.ResponseWriter, body []byte) ([]icloud.Contact, bool) {
+	var req struct {
+		Contacts []icloud.Contact `json:"contacts"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Contacts) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errorCode": "INVALID_REQUEST", "errorMessage": "no contacts in request"})
+		return nil, false
+	}
+	return req.Contacts, true
+}
+
+// findContact returns the index of the contact with the given id, or -1.
+func findContact(a *account, id string) int {
+	for i, contact := range a.Contacts {
+		if contact.ContactID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) handleContactsCreate(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.contactsAccount(w, r, true)
+	if a == nil {
+		return
+	}
+
+	contacts, ok := readContacts(w, body)
+	if !ok {
+		return
+	}
+
+	for i := range contacts {
+		if contacts[i].ContactID == "" || findContact(a, contacts[i].ContactID) >= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errorCode": "INVALID_CONTACT_ID", "errorMessage": "contactId is missing or already in use"})
+			return
+		}
+		contacts[i].Etag = s.newEtag()
+	}
+
+	a.Contacts = append(a.Contacts, contacts...)
+	a.syncToken++
+
+	writeContacts(w, a, contacts)
+}
+
+func (s *Server) handleContactsUpdate(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.contactsAccount(w, r, true)
+	if a == nil {
+		return
+	}
+
+	contacts, ok := readContacts(w, body)
+	if !ok || !s.checkEtags(w, a, contacts) {
+		return
+	}
+
+	for i := range contacts {
+		contacts[i].Etag = s.newEtag()
+		a.Contacts[findContact(a, contacts[i].ContactID)] = contacts[i]
+	}
+	a.syncToken++
+
+	writeContacts(w, a, contacts)
+}
+
+func (s *Server) handleContactsDelete(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.contactsAccount(w, r, true)
+	if a == nil {
+		return
+	}
+
+	contacts, ok := readContacts(w, body)
+	if !ok || !s.checkEtags(w, a, contacts) {
+		return
+	}
+
+	for _, contact := range contacts {
+		i := findContact(a, contact.ContactID)
+		a.Contacts = append(a.Contacts[:i], a.Contacts[i+1:]...)
+	}
+	a.syncToken++
+
+	writeContacts(w, a, []icloud.Contact{})
+}
+
+// checkEtags answers with 404 or 409 and returns false unless every contact exists and
+// was last seen at its current etag.
+func (s *Server) checkEtags(w http.ResponseWriter, a *account, contacts []icloud.Contact) bool {
+	for _, contact := range contacts {
+		i := findContact(a, contact.ContactID)
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errorCode": "NOT_FOUND", "errorMessage": "contact " + contact.ContactID + " not found"})
+			return false
+		}
+		if a.Contacts[i].Etag != contact.Etag {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"errorCode": "ETAG_MISMATCH", "errorMessage": "contact " + contact.ContactID + " was changed"})
+			return false
+		}
+	}
+	return true
+}
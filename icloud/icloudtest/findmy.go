@@ -0,0 +1,84 @@
+package icloudtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+)
+
+// findMyAccount returns the account of a Find My call, or nil after answering with an
+// error. The caller must hold s.mu.
+func (s *Server) findMyAccount(w http.ResponseWriter, r *http.Request) *account {
+	a := s.sessionAccount(r)
+	if a == nil {
+		writeSessionExpired(w)
+	}
+	return a
+}
+
+// serverContext is the context fmipservice expects to be echoed back on every call.
+func serverContext(a *account) map[string]interface{} {
+	return map[string]interface{}{
+		"prsId":                a.Dsid,
+		"timezone":             map[string]interface{}{"tzName": "America/Los_Angeles"},
+		"callbackIntervalInMS": 10000,
+		"serverTimestamp":      time.Now().UnixMilli(),
+	}
+}
+
+func (s *Server) handleRefreshClient(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.findMyAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"userInfo":      map[string]interface{}{"firstName": a.FirstName, "lastName": a.LastName},
+		"serverContext": serverContext(a),
+		"content":       append([]icloud.FMDevice{}, a.Devices...),
+		"statusCode":    "200",
+	})
+}
+
+func (s *Server) handlePlaySound(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.findMyAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req struct {
+		ServerContext json.RawMessage `json:"serverContext"`
+		Device        string          `json:"device"`
+	}
+	json.Unmarshal(body, &req)
+
+	// the web app always echoes the context from refreshClient
+	if len(req.ServerContext) == 0 || string(req.ServerContext) == "null" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"statusCode": "400", "reason": "serverContext is required"})
+		return
+	}
+
+	for i := range a.Devices {
+		if a.Devices[i].ID != req.Device {
+			continue
+		}
+
+		a.Devices[i].Snd = &icloud.FMSndStatus{
+			AlertTitle:      "Find My",
+			CreateTimestamp: time.Now().UnixMilli(),
+			StatusCode:      "200",
+		}
+		a.sounds = append(a.sounds, req.Device)
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"serverContext": serverContext(a),
+			"content":       []icloud.FMDevice{a.Devices[i]},
+			"statusCode":    "200",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{"statusCode": "404", "reason": "Device not found"})
+}
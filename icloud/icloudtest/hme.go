@@ -0,0 +1,190 @@
+package icloudtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/google/uuid"
+)
+
+// Hide My Email error codes, as maildomainws reports them.
+const (
+	codeHMELimit    = -41015
+	codeHMENotFound = -41011
+	codeHMEInvalid  = -41001
+)
+
+// hmeAccount returns the account of a Hide My Email call, or nil after answering with an
+// error. The caller must hold s.mu.
+func (s *Server) hmeAccount(w http.ResponseWriter, r *http.Request) *account {
+	a := s.sessionAccount(r)
+	if a == nil {
+		writeSessionExpired(w)
+		return nil
+	}
+
+	if !a.ICloudPlus {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"success": false,
+			"error":   map[string]interface{}{"errorCode": codeHMEInvalid, "errorMessage": "Hide My Email requires iCloud+."},
+		})
+		return nil
+	}
+
+	return a
+}
+
+// writeHME answers a Hide My Email call with Apple's success envelope around result.
+func writeHME(w http.ResponseWriter, result interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"timestamp": time.Now().Unix(),
+		"result":    result,
+	})
+}
+
+// writeHMEError answers a Hide My Email call that failed; maildomainws reports failures
+// with a 200 status.
+func writeHMEError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   false,
+		"timestamp": time.Now().Unix(),
+		"error":     map[string]interface{}{"errorCode": code, "errorMessage": message},
+	})
+}
+
+func (s *Server) handleHMEList(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.hmeAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	writeHME(w, icloud.Result{
+		ForwardToEmails:   []string{a.AppleID},
+		HmeEmails:         append([]icloud.HmeEmail{}, a.HMEs...),
+		SelectedForwardTo: a.AppleID,
+	})
+}
+
+func (s *Server) handleHMEGenerate(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.hmeAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	email := randomHex(5) + "_" + randomHex(3) + "@icloud.com"
+	a.generated[email] = true
+
+	writeHME(w, map[string]string{"hme": email})
+}
+
+func (s *Server) handleHMEReserve(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.hmeAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req struct {
+		Hme   string `json:"hme"`
+		Label string `json:"label"`
+		Note  string `json:"note"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || !a.generated[req.Hme] {
+		writeHMEError(w, codeHMEInvalid, "The address was not generated for this account.")
+		return
+	}
+
+	if a.HMELimit > 0 && a.reserved >= a.HMELimit {
+		writeHMEError(w, codeHMELimit, "You have reached the limit of addresses you can create at this time. Try again later.")
+		return
+	}
+
+	delete(a.generated, req.Hme)
+	a.reserved++
+
+	hme := icloud.HmeEmail{
+		Origin:          "ON_DEMAND",
+		AnonymousID:     strings.ToLower(uuid.New().String()),
+		ForwardToEmail:  a.AppleID,
+		Hme:             req.Hme,
+		Label:           req.Label,
+		Note:            req.Note,
+		CreateTimestamp: time.Now().UnixMilli(),
+		IsActive:        true,
+	}
+	a.HMEs = append(a.HMEs, hme)
+
+	writeHME(w, map[string]interface{}{"hme": hme})
+}
+
+// findHME returns the index of the address with the anonymousId in body, or -1 after
+// answering with an error.
+func findHME(w http.ResponseWriter, a *account, body []byte) int {
+	var req struct {
+		AnonymousID string `json:"anonymousId"`
+	}
+	json.Unmarshal(body, &req)
+
+	for i, hme := range a.HMEs {
+		if hme.AnonymousID == req.AnonymousID {
+			return i
+		}
+	}
+
+	writeHMEError(w, codeHMENotFound, "The address does not exist.")
+	return -1
+}
+
+func (s *Server) handleHMEDeactivate(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.hmeAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	i := findHME(w, a, body)
+	if i < 0 {
+		return
+	}
+	a.HMEs[i].IsActive = false
+
+	writeHME(w, map[string]interface{}{})
+}
+
+func (s *Server) handleHMEReactivate(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.hmeAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	i := findHME(w, a, body)
+	if i < 0 {
+		return
+	}
+	a.HMEs[i].IsActive = true
+
+	writeHME(w, map[string]interface{}{})
+}
+
+func (s *Server) handleHMEDelete(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.hmeAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	i := findHME(w, a, body)
+	if i < 0 {
+		return
+	}
+
+	// as on iCloud, only deactivated addresses can be deleted
+	if a.HMEs[i].IsActive {
+		writeHMEError(w, codeHMEInvalid, "The address must be deactivated before it can be deleted.")
+		return
+	}
+	a.HMEs = append(a.HMEs[:i], a.HMEs[i+1:]...)
+
+	writeHME(w, map[string]interface{}{})
+}
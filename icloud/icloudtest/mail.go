@@ -0,0 +1,243 @@
+package icloudtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+)
+
+var inboxSessionHeaders = icloud.SessionHeaders{Folder: "INBOX", Condstore: 1, Qresync: 1, Threadmode: 1}
+
+// mailAccount returns the account of a mail call, or nil after answering with an error.
+// The caller must hold s.mu.
+func (s *Server) mailAccount(w http.ResponseWriter, r *http.Request) *account {
+	a := s.sessionAccount(r)
+	if a == nil {
+		writeSessionExpired(w)
+	}
+	return a
+}
+
+func (s *Server) handleMailInbox(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.mailAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req struct {
+		MaxResults int    `json:"maxResults"`
+		Before     string `json:"before"`
+	}
+	json.Unmarshal(body, &req)
+
+	before, _ := strconv.ParseInt(req.Before, 10, 64)
+
+	mail := append([]Mail(nil), a.Mail...)
+	sort.SliceStable(mail, func(i, j int) bool { return mail[i].Date.After(mail[j].Date) })
+
+	threads := []icloud.Thread{}
+	for _, m := range mail {
+		if before > 0 && m.Date.UnixMilli() >= before {
+			continue
+		}
+		if req.MaxResults > 0 && len(threads) >= req.MaxResults {
+			break
+		}
+
+		threads = append(threads, icloud.Thread{
+			JSONType:           "Thread",
+			ThreadID:           m.ThreadID,
+			Timestamp:          m.Date.UnixMilli(),
+			Count:              1,
+			FolderMessageCount: 1,
+			Flags:              []string{},
+			Senders:            []string{m.From},
+			Subject:            m.Subject,
+			Preview:            preview(m.Body),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, icloud.MailInboxResp{
+		TotalThreadsReturned: len(threads),
+		ThreadList:           threads,
+		SessionHeaders:       inboxSessionHeaders,
+		Events:               []any{},
+	})
+}
+
+func (s *Server) handleMailMetadata(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.mailAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req struct {
+		ThreadID string `json:"threadId"`
+	}
+	json.Unmarshal(body, &req)
+
+	list := []icloud.MessageMetadata{}
+	for _, m := range a.Mail {
+		if m.ThreadID != req.ThreadID {
+			continue
+		}
+
+		list = append(list, icloud.MessageMetadata{
+			UID:       m.UID,
+			Date:      m.Date.UnixMilli(),
+			Size:      len(m.Body),
+			Folder:    "INBOX",
+			Flags:     []any{},
+			SentDate:  m.Date.Format("Mon, 2 Jan 2006 15:04:05 -0700"),
+			Subject:   m.Subject,
+			From:      []string{m.From},
+			To:        []string{m.To},
+			Cc:        []any{},
+			Bcc:       []any{},
+			MessageID: "<" + m.UID + "@icloudtest>",
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"messageMetadataList": list,
+		"sessionHeaders":      inboxSessionHeaders,
+	})
+}
+
+func (s *Server) handleMailGet(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.mailAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req struct {
+		UID string `json:"uid"`
+	}
+	json.Unmarshal(body, &req)
+
+	for _, m := range a.Mail {
+		if m.UID != req.UID {
+			continue
+		}
+
+		writeJSON(w, http.StatusOK, icloud.Message{
+			GUID:           "INBOX/" + m.UID,
+			To:             []string{m.To},
+			From:           []string{m.From},
+			Cc:             []string{},
+			Bcc:            []string{},
+			ContentType:    "text/html",
+			Parts:          []icloud.MsgPart{{GUID: "INBOX/" + m.UID + "/2.1", Content: m.Body}},
+			SessionHeaders: inboxSessionHeaders,
+			Events:         []any{},
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{
+		"error": map[string]interface{}{"code": "NOT_FOUND", "message": "Message not found"},
+	})
+}
+
+// writeRPC answers a webmail JSON-RPC call.
+func writeRPC(w http.ResponseWriter, result interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"result":  result,
+	})
+}
+
+func (s *Server) handleMailDelete(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.mailAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req struct {
+		Params struct {
+			UIDs []string `json:"uids"`
+		} `json:"params"`
+	}
+	json.Unmarshal(body, &req)
+
+	var deleted []string
+	for _, uid := range req.Params.UIDs {
+		for i, m := range a.Mail {
+			if m.UID == uid {
+				a.Mail = append(a.Mail[:i], a.Mail[i+1:]...)
+				deleted = append(deleted, uid)
+				break
+			}
+		}
+	}
+
+	writeRPC(w, map[string]interface{}{"deletedUids": strings.Join(deleted, ",")})
+}
+
+func (s *Server) handleMailDraft(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.mailAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req icloud.MailDraftReq
+	if err := json.Unmarshal(body, &req); err != nil || req.Method != "saveDraft" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"jsonrpc": "2.0",
+			"error":   map[string]interface{}{"code": -32601, "message": "Method not found"},
+		})
+		return
+	}
+
+	uid := strconv.Itoa(s.newSeq())
+	a.drafts[uid] = SentMail{
+		From:     req.Params.From,
+		To:       req.Params.To,
+		Subject:  req.Params.Subject,
+		TextBody: req.Params.TextBody,
+		Body:     req.Params.Body,
+	}
+
+	writeRPC(w, map[string]interface{}{"uid": uid})
+}
+
+func (s *Server) handleMailSend(w http.ResponseWriter, r *http.Request, body []byte) {
+	a := s.mailAccount(w, r)
+	if a == nil {
+		return
+	}
+
+	var req struct {
+		MessageGUID string `json:"messageGuid"`
+	}
+	json.Unmarshal(body, &req)
+
+	uid := strings.TrimPrefix(req.MessageGUID, "Drafts/")
+	draft, ok := a.drafts[uid]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"error": map[string]interface{}{"code": "NOT_FOUND", "message": "Draft not found"},
+		})
+		return
+	}
+
+	delete(a.drafts, uid)
+	a.sent = append(a.sent, draft)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessionHeaders": inboxSessionHeaders,
+	})
+}
+
+// preview returns the start of a message body, as shown in the thread list.
+func preview(body string) string {
+	const max = 100
+	if len(body) > max {
+		return body[:max]
+	}
+	return body
+}
@@ -0,0 +1,654 @@
+// Package icloudtest provides an in-process fake of the iCloud web APIs used by the icloud
+// package, so code built on icloud.Client can be tested without an Apple ID or network
+// access.
+//
+// The fake signs clients in through the same SRP exchange as Apple, verifying the password
+// for real, and can ask for a two-factor code. Its accounts are seeded with Hide My Email
+// addresses, mail, Find My devices and contacts, which the client can read and change.
+// Failures can be injected per endpoint:
+//
+//	srv := icloudtest.NewServer()
+//	defer srv.Close()
+//
+//	srv.AddAccount(icloudtest.Account{AppleID: "user@example.com", Password: "secret", ICloudPlus: true})
+//	srv.InjectFailure(icloudtest.Failure{Endpoint: "hme.reserve", Status: 503, Times: 1})
+//
+//	client, _ := srv.NewClient("user@example.com", "secret")
+//	err := client.Login(nil)
+package icloudtest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/internal/srp"
+)
+
+// webAuthCookie is the cookie that carries the iCloud web session.
+const webAuthCookie = "X-APPLE-WEBAUTH-TOKEN"
+
+// Account seeds an Apple ID on the server.
+type Account struct {
+	AppleID  string
+	Password string
+
+	// Dsid is the account's iCloud person id. A unique one is assigned if empty.
+	Dsid      string
+	FirstName string
+	LastName  string
+
+	// Protocol is the password protocol offered at sign in, "s2k" (the default) or
+	// "s2k_fo". Iterations is the PBKDF2 iteration count and defaults to 1000.
+	Protocol   string
+	Iterations int
+
+	// TwoFactor makes the sign in ask for a code, unless the client sends the trust token
+	// of an earlier two-factor login. Code is the code the server accepts, "123456" unless
+	// set. TrustedPhones default to a single number with id 1.
+	TwoFactor        bool
+	Code             string
+	TrustedPhones    []icloud.TrustedPhoneNumber
+	NoTrustedDevices bool
+
+	// Locked makes every sign in fail as Apple does for an Apple ID locked for security
+	// reasons.
+	Locked bool
+
+	// OmitServerProof leaves the server's SRP proof out of the sign in response, and
+	// WrongServerProof sends one that does not match the client's.
+	OmitServerProof  bool
+	WrongServerProof bool
+
+	// ICloudPlus reports an active iCloud+ subscription, which Hide My Email requires.
+	ICloudPlus bool
+	// HMELimit is the number of Hide My Email addresses that can be reserved before Apple's
+	// limit error is returned. Zero means no limit.
+	HMELimit int
+
+	HMEs     []icloud.HmeEmail
+	Mail     []Mail
+	Devices  []icloud.FMDevice
+	Contacts []icloud.Contact
+}
+
+// Mail is a message in an account's inbox. UID and ThreadID are assigned if empty.
+type Mail struct {
+	UID      string
+	ThreadID string
+	From     string
+	To       string
+	Subject  string
+	Body     string
+	Date     time.Time
+}
+
+// SentMail is a draft sent through the server.
+type SentMail struct {
+	From     string
+	To       string
+	Subject  string
+	TextBody string
+	Body     string
+}
+
+// Failure makes the server answer calls to an endpoint with an error instead of handling
+// them.
+type Failure struct {
+	// Endpoint names the calls to fail as they are reported in icloud.APIError, e.g.
+	// "hme.reserve" or "auth.complete". A service name such as "findmy" fails all of its
+	// calls.
+	Endpoint string
+
+	// Status is the HTTP status to answer with, 500 unless set.
+	Status int
+	// Body is the response body, an empty JSON object unless set.
+	Body string
+	// Header is added to the response, e.g. a Retry-After.
+	Header http.Header
+
+	// Times is the number of calls to fail. Zero fails every call until ClearFailures.
+	Times int
+}
+
+// Server is a fake iCloud. All of Apple's hosts are served from URL, which a client is
+// pointed at with Region.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:51234.
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	accounts map[string]*account
+	failures []*Failure
+	calls    map[string]int
+	nextID   int
+
+	signins       map[string]*signin  // by signin/init challenge id
+	challenges    map[string]*account // two-factor challenges, by X-Apple-ID-Session-Id
+	verified      map[string]bool     // challenges whose code was accepted
+	sessionTokens map[string]*account // X-Apple-Session-Token
+	trustTokens   map[string]*account // X-Apple-TwoSV-Trust-Token
+	webSessions   map[string]*account // webAuthCookie values
+}
+
+// account is an Account together with the state the server keeps for it.
+type account struct {
+	Account
+
+	salt     []byte
+	verifier []byte
+
+	generated map[string]bool // addresses handed out by hme.generate and not yet reserved
+	reserved  int
+	drafts    map[string]SentMail
+	sent      []SentMail
+	sounds    []string
+	syncToken int
+}
+
+// signin is a sign in between signin/init and signin/complete.
+type signin struct {
+	acct *account
+	srp  *srp.SRPServer
+	a    []byte
+}
+
+// NewServer starts a server with no accounts. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		accounts:      make(map[string]*account),
+		calls:         make(map[string]int),
+		signins:       make(map[string]*signin),
+		challenges:    make(map[string]*account),
+		verified:      make(map[string]bool),
+		sessionTokens: make(map[string]*account),
+		trustTokens:   make(map[string]*account),
+		webSessions:   make(map[string]*account),
+	}
+
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Region returns the region that sends all of a client's requests to the server.
+func (s *Server) Region() icloud.Region {
+	return icloud.Region{
+		Name:        "icloudtest",
+		AuthOrigin:  s.URL,
+		SetupOrigin: s.URL,
+		HomeOrigin:  s.URL,
+		Domain:      "icloudtest",
+		Country:     "USA",
+	}
+}
+
+// ClientOptions returns the options that point a client at the server, for clients that
+// are not created with NewClient, e.g. through icloud.PoolOptions. Retries are disabled so
+// injected failures surface at once.
+func (s *Server) ClientOptions() []icloud.Option {
+	return []icloud.Option{
+		icloud.WithRegion(s.Region()),
+		icloud.WithTransport(icloud.NewStdTransport(&http.Client{Transport: s.srv.Client().Transport})),
+		icloud.WithRetryPolicy(icloud.RetryPolicy{}),
+	}
+}
+
+// NewClient returns a client for the given Apple ID that talks to the server, configured
+// with ClientOptions. Pass icloud.WithRetryPolicy to test retries. opts are applied last.
+func (s *Server) NewClient(username, password string, opts ...icloud.Option) (*icloud.Client, error) {
+	base := append([]icloud.Option{icloud.WithCredentials(username, password)}, s.ClientOptions()...)
+
+	return icloud.NewClientWithOptions(append(base, opts...)...)
+}
+
+// AddAccount seeds an account. Adding an Apple ID again replaces the account and ends its
+// sessions.
+func (s *Server) AddAccount(acct Account) error {
+	if acct.AppleID == "" {
+		return fmt.Errorf("icloudtest: account has no AppleID")
+	}
+
+	if acct.Protocol == "" {
+		acct.Protocol = srp.ProtocolS2K
+	}
+	if acct.Iterations <= 0 {
+		acct.Iterations = 1000
+	}
+	if acct.Code == "" {
+		acct.Code = "123456"
+	}
+	if len(acct.TrustedPhones) == 0 {
+		acct.TrustedPhones = []icloud.TrustedPhoneNumber{{
+			ID:                 1,
+			NumberWithDialCode: "+1 (•••) •••-••00",
+			ObfuscatedNumber:   "(•••) •••-••00",
+			LastTwoDigits:      "00",
+			PushMode:           "sms",
+		}}
+	}
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	verifier, err := srp.AppleVerifier(acct.Protocol, []byte(acct.Password), salt, acct.Iterations)
+	if err != nil {
+		return fmt.Errorf("icloudtest: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if acct.Dsid == "" {
+		acct.Dsid = fmt.Sprintf("%d", 10000000000+s.newSeq())
+	}
+
+	a := &account{
+		Account:   acct,
+		salt:      salt,
+		verifier:  verifier,
+		generated: make(map[string]bool),
+		drafts:    make(map[string]SentMail),
+	}
+
+	// copy the seeded state so the caller's slices are never written to
+	a.HMEs = append([]icloud.HmeEmail(nil), acct.HMEs...)
+	a.Devices = append([]icloud.FMDevice(nil), acct.Devices...)
+	a.Contacts = append([]icloud.Contact(nil), acct.Contacts...)
+	a.Mail = append([]Mail(nil), acct.Mail...)
+
+	for i := range a.Mail {
+		m := &a.Mail[i]
+		if m.UID == "" {
+			m.UID = fmt.Sprintf("%d", s.newSeq())
+		}
+		if m.ThreadID == "" {
+			m.ThreadID = "thread-" + m.UID
+		}
+		if m.Date.IsZero() {
+			m.Date = time.Now()
+		}
+	}
+	for i := range a.Contacts {
+		if a.Contacts[i].Etag == "" {
+			a.Contacts[i].Etag = s.newEtag()
+		}
+	}
+
+	key := accountKey(acct.AppleID)
+	if old, ok := s.accounts[key]; ok {
+		s.endSessions(old, true)
+	}
+	s.accounts[key] = a
+
+	return nil
+}
+
+// InjectFailure makes calls to f.Endpoint fail. Failures are matched in the order they
+// were injected.
+func (s *Server) InjectFailure(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &f)
+}
+
+// ClearFailures removes all injected failures.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = nil
+}
+
+// Calls returns how many requests the server received for endpoint, a name as in
+// Failure.Endpoint. Failed calls are counted too.
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.Contains(endpoint, ".") {
+		return s.calls[endpoint]
+	}
+
+	n := 0
+	for name, count := range s.calls {
+		if strings.HasPrefix(name, endpoint+".") {
+			n += count
+		}
+	}
+	return n
+}
+
+// ExpireSessions ends the account's iCloud web sessions, so its clients get a 450 on the
+// next service call. The session and trust tokens stay valid, so a client can sign back
+// in through accountLogin without a password or code.
+func (s *Server) ExpireSessions(appleID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.accounts[accountKey(appleID)]; ok {
+		s.endSessions(a, false)
+	}
+}
+
+// RevokeSessions ends every session of the account, including its session and trust
+// tokens, so its clients have to sign in with the password and two-factor code again.
+func (s *Server) RevokeSessions(appleID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.accounts[accountKey(appleID)]; ok {
+		s.endSessions(a, true)
+	}
+}
+
+// HMEs returns the account's Hide My Email addresses.
+func (s *Server) HMEs(appleID string) []icloud.HmeEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.accounts[accountKey(appleID)]; ok {
+		return append([]icloud.HmeEmail(nil), a.HMEs...)
+	}
+	return nil
+}
+
+// Inbox returns the mail in the account's inbox.
+func (s *Server) Inbox(appleID string) []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.accounts[accountKey(appleID)]; ok {
+		return append([]Mail(nil), a.Mail...)
+	}
+	return nil
+}
+
+// SentMail returns the drafts the account sent, oldest first.
+func (s *Server) SentMail(appleID string) []SentMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.accounts[accountKey(appleID)]; ok {
+		return append([]SentMail(nil), a.sent...)
+	}
+	return nil
+}
+
+// SoundsPlayed returns the ids of the devices a sound was played on, oldest first.
+func (s *Server) SoundsPlayed(appleID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.accounts[accountKey(appleID)]; ok {
+		return append([]string(nil), a.sounds...)
+	}
+	return nil
+}
+
+// Contacts returns the account's contacts.
+func (s *Server) Contacts(appleID string) []icloud.Contact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.accounts[accountKey(appleID)]; ok {
+		return append([]icloud.Contact(nil), a.Contacts...)
+	}
+	return nil
+}
+
+// handlerFunc handles a request whose body has already been read.
+type handlerFunc func(w http.ResponseWriter, r *http.Request, body []byte)
+
+// ServeHTTP routes a request to its handler, unless an injected failure answers it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name, handler := s.route(r, body)
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[name]++
+
+	if f := s.takeFailure(name); f != nil {
+		for key, values := range f.Header {
+			w.Header()[key] = values
+		}
+
+		status, failBody := f.Status, f.Body
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		if failBody == "" {
+			failBody = "{}"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, failBody)
+		return
+	}
+
+	handler(w, r, body)
+}
+
+// route returns the endpoint name and handler for r.
+func (s *Server) route(r *http.Request, body []byte) (string, handlerFunc) {
+	path := r.URL.Path
+
+	switch {
+	case path == "/appleauth/auth/authorize/signin":
+		return "auth.start", s.handleAuthStart
+	case path == "/appleauth/auth/federate":
+		return "auth.federate", s.handleFederate
+	case path == "/appleauth/auth/signin/init":
+		return "auth.init", s.handleSigninInit
+	case path == "/appleauth/auth/signin/complete":
+		return "auth.complete", s.handleSigninComplete
+	case path == "/appleauth/auth" && r.Method == http.MethodGet:
+		return "auth.options", s.handleAuthOptions
+	case path == "/appleauth/auth/verify/phone":
+		return "auth.requestPhoneCode", s.handleRequestPhoneCode
+	case path == "/appleauth/auth/verify/trusteddevice/securitycode", path == "/appleauth/auth/verify/phone/securitycode":
+		return "auth.submitSecurityCode", s.handleSecurityCode
+	case path == "/appleauth/auth/2sv/trust":
+		return "auth.trust", s.handleTrust
+
+	case path == "/setup/ws/1/accountLogin":
+		if r.URL.Query().Get("dsid") != "" {
+			return "auth.partitionLogin", s.handleAccountLogin
+		}
+		return "auth.accountLogin", s.handleAccountLogin
+	case path == "/setup/ws/1/validate":
+		return "auth.validate", s.handleValidate
+	case path == "/setup/ws/1/logout":
+		return "auth.logout", s.handleLogout
+	case path == "/setup/ws/1/storageUsageInfo":
+		return "account.storage", s.handleStorage
+	case path == "/setup/web/family/getFamilyDetailsForClient":
+		return "account.family", s.handleFamily
+
+	case path == "/v2/hme/list":
+		return "hme.list", s.handleHMEList
+	case path == "/v1/hme/generate":
+		return "hme.generate", s.handleHMEGenerate
+	case path == "/v1/hme/reserve":
+		return "hme.reserve", s.handleHMEReserve
+	case path == "/v1/hme/deactivate":
+		return "hme.deactivate", s.handleHMEDeactivate
+	case path == "/v1/hme/reactivate":
+		return "hme.reactivate", s.handleHMEReactivate
+	case path == "/v1/hme/delete":
+		return "hme.delete", s.handleHMEDelete
+
+	case path == "/mailws2/v1/thread/search":
+		return "mail.inbox", s.handleMailInbox
+	case path == "/mailws2/v1/thread/get":
+		return "mail.metadata", s.handleMailMetadata
+	case path == "/mailws2/v1/message/get":
+		return "mail.get", s.handleMailGet
+	case path == "/wm/message":
+		// both JSON-RPC calls share the path
+		var rpc struct {
+			Method string `json:"method"`
+		}
+		json.Unmarshal(body, &rpc)
+		if rpc.Method == "delete" {
+			return "mail.delete", s.handleMailDelete
+		}
+		return "mail.draft", s.handleMailDraft
+	case path == "/mailws2/v1/draft/send":
+		return "mail.send", s.handleMailSend
+
+	case path == "/fmipservice/client/web/refreshClient":
+		return "findmy.refreshClient", s.handleRefreshClient
+	case path == "/fmipservice/client/web/playSound":
+		return "findmy.playSound", s.handlePlaySound
+
+	case path == "/co/startup":
+		return "contacts.startup", s.handleContactsStartup
+	case path == "/co/contacts/card/":
+		switch {
+		case r.Method == http.MethodGet:
+			return "contacts.list", s.handleContactsList
+		case r.URL.Query().Get("method") == http.MethodPut:
+			return "contacts.update", s.handleContactsUpdate
+		case r.URL.Query().Get("method") == http.MethodDelete:
+			return "contacts.delete", s.handleContactsDelete
+		default:
+			return "contacts.create", s.handleContactsCreate
+		}
+	}
+
+	return "", nil
+}
+
+// takeFailure returns the first injected failure matching the endpoint name and counts it
+// down. The caller must hold s.mu.
+func (s *Server) takeFailure(name string) *Failure {
+	service := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		service = name[:i]
+	}
+
+	for i, f := range s.failures {
+		if f.Endpoint != name && f.Endpoint != service {
+			continue
+		}
+
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.failures = append(s.failures[:i:i], s.failures[i+1:]...)
+			}
+		}
+		return f
+	}
+
+	return nil
+}
+
+// sessionAccount returns the account whose iCloud web session r carries, or nil. The
+// caller must hold s.mu.
+func (s *Server) sessionAccount(r *http.Request) *account {
+	cookie, err := r.Cookie(webAuthCookie)
+	if err != nil {
+		return nil
+	}
+	return s.webSessions[cookie.Value]
+}
+
+// endSessions drops the account's web sessions, and with tokens also its session and
+// trust tokens. The caller must hold s.mu.
+func (s *Server) endSessions(a *account, tokens bool) {
+	for id, owner := range s.webSessions {
+		if owner == a {
+			delete(s.webSessions, id)
+		}
+	}
+
+	if !tokens {
+		return
+	}
+
+	for token, owner := range s.sessionTokens {
+		if owner == a {
+			delete(s.sessionTokens, token)
+		}
+	}
+	for token, owner := range s.trustTokens {
+		if owner == a {
+			delete(s.trustTokens, token)
+		}
+	}
+}
+
+// newSeq returns the next number in the server's sequence. The caller must hold s.mu.
+func (s *Server) newSeq() int {
+	s.nextID++
+	return s.nextID
+}
+
+// newEtag returns a fresh contact etag. The caller must hold s.mu.
+func (s *Server) newEtag() string {
+	return fmt.Sprintf("C=%d@U=%s", s.newSeq(), randomHex(8))
+}
+
+func accountKey(appleID string) string {
+	return strings.ToLower(strings.TrimSpace(appleID))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeJSON answers with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// writeServiceError answers with Apple's serviceErrors format.
+func writeServiceError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"serviceErrors": []icloud.ServiceError{{Code: code, Message: message}},
+	})
+}
@@ -0,0 +1,131 @@
+package icloud
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// requestIDHeaders are the response headers Apple identifies a request by. They are worth
+// quoting when reporting a problem to Apple and carry no secrets.
+var requestIDHeaders = []string{
+	"X-Apple-Request-UUID",
+	"X-Apple-Jingle-Correlation-Key",
+	"X-Apple-I-Request-ID",
+	"X-Responding-Instance",
+}
+
+// secretHeaders are the headers whose values are never logged, in canonical form.
+var secretHeaders = map[string]bool{
+	"Authorization":                true,
+	"Cookie":                       true,
+	"Set-Cookie":                   true,
+	"Scnt":                         true,
+	"X-Apple-Id-Session-Id":        true,
+	"X-Apple-Session-Token":        true,
+	"X-Apple-Twosv-Trust-Token":    true,
+	"X-Apple-Auth-Attributes":      true,
+	"X-Apple-I-Fd-Client-Info":     true,
+	"X-Apple-Repair-Session-Token": true,
+}
+
+const redacted = "REDACTED"
+
+// logRequest records a finished request to c.Logger: the endpoint, method, status, latency
+// and Apple's request IDs at info level, or warning level if no response came back. At
+// debug level the request and response headers are added, with secret values replaced.
+// Bodies, which carry passwords, SRP values and message contents, are never logged.
+func (c *Client) logRequest(ctx context.Context, ep endpoint, req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	logger := c.Logger
+	if logger == nil {
+		return
+	}
+
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("endpoint", ep.String()),
+		slog.String("method", req.Method),
+		slog.String("host", req.URL.Host),
+		slog.Duration("latency", latency),
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.String("error", transportError(err)))
+		logger.LogAttrs(ctx, level, "icloud request failed", attrs...)
+		return
+	}
+
+	attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	for _, name := range requestIDHeaders {
+		if v := resp.Header.Get(name); v != "" {
+			attrs = append(attrs, slog.String(name, v))
+		}
+	}
+
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		attrs = append(attrs,
+			slog.Any("request_header", redactHeader(req.Header)),
+			slog.Any("response_header", redactHeader(resp.Header)),
+		)
+	}
+
+	logger.LogAttrs(ctx, level, "icloud request", attrs...)
+}
+
+// logUnverifiedSignin warns that a sign in was accepted without a server proof, so the
+// client could not tell Apple from a server that does not know the password verifier.
+func (c *Client) logUnverifiedSignin(ctx context.Context) {
+	if c.Logger == nil {
+		return
+	}
+
+	c.Logger.LogAttrs(ctx, slog.LevelWarn, "icloud sign in has no server proof, the server was not verified",
+		slog.String("endpoint", authComplete.String()),
+	)
+}
+
+// transportError returns the message of a transport error without the request URL, which
+// carries the dsid and client id. The endpoint and host are logged next to it instead.
+func transportError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.Err != nil {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// redactHeader returns the headers as a log group, with the values of secretHeaders
+// replaced and fhttp's header order keys left out.
+func redactHeader(h http.Header) slog.Value {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		if key != http.HeaderOrderKey && key != http.PHeaderOrderKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		value := strings.Join(h[key], ", ")
+		if secretHeaders[http.CanonicalHeaderKey(key)] {
+			value = redacted
+		}
+		attrs = append(attrs, slog.String(key, value))
+	}
+
+	return slog.GroupValue(attrs...)
+}
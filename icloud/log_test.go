@@ -0,0 +1,182 @@
+package icloud_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// secretLogHeaders are the headers whose values must never reach the log.
+var secretLogHeaders = []string{
+	"X-Apple-Session-Token",
+	"X-Apple-TwoSV-Trust-Token",
+	"scnt",
+	"X-Apple-ID-Session-Id",
+	"X-Apple-I-FD-Client-Info",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// logBuffer is a bytes.Buffer that is safe to log to concurrently.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// headerCapture passes requests through to inner and collects the values of the
+// secretLogHeaders sent and received, and of the cookies in them. It puts the cookies on
+// the request itself, as a transport may, so the logged request carries a Cookie header.
+type headerCapture struct {
+	inner icloud.Transport
+
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func (h *headerCapture) Do(req *http.Request) (*http.Response, error) {
+	for _, cookie := range h.inner.GetCookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+	h.collect(req.Header)
+
+	resp, err := h.inner.Do(req)
+	if err == nil {
+		h.collect(resp.Header)
+	}
+	return resp, err
+}
+
+func (h *headerCapture) collect(header http.Header) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, name := range secretLogHeaders {
+		for _, v := range header.Values(name) {
+			if v == "" {
+				continue
+			}
+			h.secrets[v] = name
+
+			// cookie values are checked on their own, as a log could quote them without the name
+			pairs := strings.Split(v, ";")
+			if name == "Set-Cookie" {
+				pairs = pairs[:1]
+			}
+			for _, pair := range pairs {
+				if _, value, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && value != "" {
+					h.secrets[value] = name
+				}
+			}
+		}
+	}
+}
+
+func (h *headerCapture) GetCookies(u *url.URL) []*http.Cookie {
+	return h.inner.GetCookies(u)
+}
+
+func (h *headerCapture) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	h.inner.SetCookies(u, cookies)
+}
+
+func TestDebugLogRedactsSecrets(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+
+	const (
+		dsid     = "5566778899"
+		password = "correct horse battery staple"
+	)
+	err := srv.AddAccount(icloudtest.Account{
+		AppleID:    "user@icloud.com",
+		Password:   password,
+		Dsid:       dsid,
+		TwoFactor:  true,
+		ICloudPlus: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs logBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client, err := srv.NewClient("user@icloud.com", password, icloud.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture := &headerCapture{inner: client.HttpClient, secrets: make(map[string]string)}
+	client.HttpClient = capture
+
+	if err := client.Login(func() (string, error) { return "123456", nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReserveHME("label", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("headers", func(t *testing.T) {
+		out := logs.String()
+
+		seen := make(map[string]bool)
+		for secret, name := range capture.secrets {
+			seen[name] = true
+			if strings.Contains(out, secret) {
+				t.Errorf("the log contains the value of %s", name)
+			}
+		}
+
+		// the test is only as good as the headers it saw go over the wire
+		for _, name := range []string{"X-Apple-Session-Token", "X-Apple-TwoSV-Trust-Token", "scnt", "X-Apple-I-FD-Client-Info", "Cookie", "Set-Cookie"} {
+			if !seen[name] {
+				t.Errorf("no %s was sent or received", name)
+			}
+		}
+
+		if !strings.Contains(out, "X-Apple-Session-Token=REDACTED") {
+			t.Error("the debug log does not list the redacted headers")
+		}
+		if strings.Contains(out, password) {
+			t.Error("the log contains the password")
+		}
+	})
+
+	t.Run("transport error", func(t *testing.T) {
+		before := len(logs.String())
+
+		// the validate URL carries the dsid and client id
+		srv.Close()
+		if _, err := client.ValidateSession(); err == nil {
+			t.Fatal("ValidateSession succeeded against a closed server")
+		}
+
+		out := logs.String()[before:]
+		if !strings.Contains(out, "icloud request failed") {
+			t.Fatalf("the failure was not logged: %s", out)
+		}
+		for _, leak := range []string{dsid, "clientId=", "/setup/ws/1/validate"} {
+			if strings.Contains(out, leak) {
+				t.Errorf("the transport error is logged with its URL: %s", out)
+			}
+		}
+	})
+}
@@ -1,10 +1,10 @@
 package icloud
 
 import (
-	"crypto/sha256"
+	"context"
+	"errors"
 	"fmt"
-
-	"golang.org/x/crypto/pbkdf2"
+	"sync/atomic"
 
 	b64 "encoding/base64"
 
@@ -14,84 +14,201 @@ import (
 
 // Login logs in to iCloud and authenticates the user to access any iCloud web app/service.
 // The otpProvider callback is called when two-factor authentication is required.
+//
+// If a SessionStore is set, a stored session that is still valid is resumed instead and
+// otpProvider is not called. After a full login the new session is saved to the store.
 func (c *Client) Login(otpProvider OTPProvider) error {
-	err := c.loginInit(otpProvider)
+	return c.LoginContext(context.Background(), otpProvider)
+}
+
+// LoginContext is like Login but aborts when ctx is cancelled or its deadline expires,
+// including while waiting for otpProvider to return a code.
+func (c *Client) LoginContext(ctx context.Context, otpProvider OTPProvider) error {
+	var handler TwoFactorHandler
+	if otpProvider != nil {
+		handler = otpHandler{provider: otpProvider, selector: c.PhoneSelector}
+	}
+
+	return c.LoginWithHandlerContext(ctx, handler)
+}
+
+// LoginWithHandler is like Login, but drives two-factor authentication through handler,
+// which is given the available delivery methods and can request resends and retry wrong
+// codes. A nil handler makes Login fail with ErrTwoFactorRequired if Apple asks for a code.
+func (c *Client) LoginWithHandler(handler TwoFactorHandler) error {
+	return c.LoginWithHandlerContext(context.Background(), handler)
+}
+
+// LoginWithHandlerContext is like LoginWithHandler but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) LoginWithHandlerContext(ctx context.Context, handler TwoFactorHandler) (err error) {
+	ctx, op := c.startOperation(ctx, "auth.Login")
+	defer func() { op.end(err) }()
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	c.loginHandler = handler
+
+	if c.SessionStore != nil && c.resumeSession(ctx) {
+		return nil
+	}
+
+	err = c.login(ctx, handler)
+	if err == nil {
+		// requests that failed on the old session must not log in yet again
+		c.reauthErr = nil
+		atomic.AddUint64(&c.authGen, 1)
+	}
+
+	return err
+}
+
+// login runs the full authentication flow and saves the new session.
+func (c *Client) login(ctx context.Context, handler TwoFactorHandler) error {
+	if err := c.RateLimiter.wait(ctx, c.Username, authStart); err != nil {
+		return err
+	}
+
+	twoFactor, err := c.loginInit(ctx, handler)
 	if err != nil {
 		return err
 	}
 
-	err = c.getTrust()
+	// * A stored trust token lets Apple skip 2FA, in which case the session token is
+	// already set and the browser does not need to be trusted again
+	if twoFactor {
+		err = c.getTrust(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.authenticateWeb(ctx)
 	if err != nil {
 		return err
 	}
 
-	return c.authenticateWeb()
+	return c.saveSession()
 }
 
-// loginInit handles the login process up to the point of trusting the device.
-func (c *Client) loginInit(otpProvider OTPProvider) error {
-	err := c.authStart()
+// resumeSession loads the stored session if the client does not have one yet and reports
+// whether it is still valid. Any failure just means a full login is needed.
+func (c *Client) resumeSession(ctx context.Context) bool {
+	if c.dsid == "" {
+		state, err := c.SessionStore.Load(c.Username)
+		if err != nil {
+			return false
+		}
+
+		if err := c.loadSession(state); err != nil {
+			return false
+		}
+	}
+
+	valid, err := c.ValidateSessionContext(ctx)
+	return err == nil && valid
+}
+
+// saveSession writes the current session to the SessionStore, if one is set.
+func (c *Client) saveSession() error {
+	if c.SessionStore == nil {
+		return nil
+	}
+
+	state, err := c.ExportSession()
 	if err != nil {
 		return err
 	}
 
-	err = c.authFederate(c.Username)
+	if err := c.SessionStore.Save(c.Username, state); err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+
+	return nil
+}
+
+// loginInit handles the login process up to the point of trusting the device.
+// It reports whether two-factor authentication was required.
+func (c *Client) loginInit(ctx context.Context, handler TwoFactorHandler) (bool, error) {
+	err := c.authStart(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	params := srp.GetParams(2048)
-	params.NoUserNameInX = true // this is required for Apple's implementation
+	err = c.authFederate(ctx, c.Username)
+	if errors.Is(err, ErrRegionChanged) {
+		// the sign in has to start over on the hosts of the account's region
+		if err = c.authStart(ctx); err == nil {
+			err = c.authFederate(ctx, c.Username)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
 
-	client := srp.NewSRPClient(params, nil)
+	client := srp.NewSRPClient(srp.AppleParams(), nil)
 
 	// * Get the salt and B from the server
-	authInitResp, err := c.authInit(b64.StdEncoding.EncodeToString(client.GetABytes()), c.Username)
+	authInitResp, err := c.authInit(ctx, b64.StdEncoding.EncodeToString(client.GetABytes()), c.Username)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// * Both the salt and B are base64 encoded so we need to decode them
 	bDec, err := b64.StdEncoding.DecodeString(authInitResp.B)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	saltDec, err := b64.StdEncoding.DecodeString(authInitResp.Salt)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	// * Generate the password key using the protocol Apple picked for this account
+	protocol := authInitResp.Protocol
+	if protocol == "" {
+		protocol = srp.ProtocolS2K
 	}
 
-	// * Generate the password key
-	passHash := sha256.Sum256([]byte(c.Password))
-	passKey := pbkdf2.Key(passHash[:], []byte(saltDec), authInitResp.Iteration, 32, sha256.New)
+	passKey, err := srp.PasswordKey(protocol, []byte(c.Password), saltDec, authInitResp.Iteration)
+	if err != nil {
+		return false, err
+	}
 
 	// * Process the challenge using the server provided salt and B
 	client.ProcessClientChanllenge([]byte(c.Username), passKey, saltDec, bDec)
 
-	return c.authComplete(c.Username, authInitResp.C, b64.StdEncoding.EncodeToString(client.M1), b64.StdEncoding.EncodeToString(client.M2), otpProvider)
+	return c.authComplete(ctx, c.Username, authInitResp.C, client, handler)
 }
 
 // * getTrust() Gets the trust and auth tokens, allowing for completing user authentication for iCloud web services
-func (c *Client) getTrust() (err error) {
-	req, err := http.NewRequest(http.MethodGet, endpoints[trust], nil)
+func (c *Client) getTrust(ctx context.Context) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.regionURL(trust), nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header = c.updateRequestHeaders(req.Header.Clone())
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.roundTrip(trust, req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 204 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return readAPIError(trust, resp)
 	}
 
+	c.mu.Lock()
 	c.authToken = resp.Header.Get("X-Apple-Session-Token")
 	c.trustToken = resp.Header.Get("X-Apple-TwoSV-Trust-Token")
+	c.mu.Unlock()
 
 	return nil
 }
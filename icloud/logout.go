@@ -0,0 +1,200 @@
+package icloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// LogoutOptions controls how much Logout revokes.
+type LogoutOptions struct {
+	// ForgetTrust drops the device trust, so the next Login on this machine needs a
+	// two-factor code again. The trust token is cleared and the stored session deleted.
+	ForgetTrust bool
+
+	// AllBrowsers signs the account out of every browser and client, not just this one.
+	AllBrowsers bool
+}
+
+// Logout ends the iCloud session on Apple's side, clears the session cookies, tokens and
+// all cached service state, and marks the client closed. Any later call on the client
+// fails with ErrClientClosed.
+//
+// Without ForgetTrust the trust token is kept in the SessionStore, if one is set, so a new
+// client can log in again without two-factor authentication. If Apple can not be reached
+// the client is left untouched and Logout can be retried. If the Transport's cookie jar
+// still holds session cookies afterwards, the client is closed all the same and an error
+// reports the cookies left.
+func (c *Client) Logout(ctx context.Context, opts LogoutOptions) (err error) {
+	ctx, op := c.startOperation(ctx, "auth.Logout")
+	defer func() { op.end(err) }()
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	if c.dsid != "" {
+		if err := c.reqLogout(ctx, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.ForgetTrust {
+		c.mu.Lock()
+		c.trustToken = ""
+		c.mu.Unlock()
+	}
+
+	if err := c.revokeStoredSession(opts); err != nil {
+		return err
+	}
+
+	// the session is over on Apple's side, so the client is closed even if cookies are left
+	cookieErr := c.clearCookies()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authToken = ""
+	c.authAttr = ""
+	c.sessionID = ""
+	c.scnt = ""
+	c.dsid = ""
+	c.webservices = nil
+	c.accountInfo = nil
+	c.fmServerCtx = nil
+	c.syncToken = ""
+	c.prefToken = ""
+	c.loginHandler = nil
+	c.closed = true
+
+	return cookieErr
+}
+
+func (c *Client) reqLogout(ctx context.Context, opts LogoutOptions) error {
+	data, err := json.Marshal(struct {
+		TrustBrowser bool `json:"trustBrowser"`
+		AllBrowsers  bool `json:"allBrowsers"`
+	}{
+		TrustBrowser: !opts.ForgetTrust,
+		AllBrowsers:  opts.AllBrowsers,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(c.regionURL(authLogout), c.frameId, c.dsid), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(HdrContentType, "text/plain;charset=UTF-8")
+	req.Header.Set("origin", c.currentRegion().HomeOrigin)
+	req.Header.Set("referer", c.currentRegion().HomeOrigin+"/")
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set(HdrAccept, "*/*")
+
+	resp, err := c.roundTrip(authLogout, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// a session Apple already considers expired is as good as logged out
+	if resp.StatusCode != 200 && !sessionExpired(resp.StatusCode) {
+		return readAPIError(authLogout, resp)
+	}
+
+	return nil
+}
+
+// revokeStoredSession removes the session from the SessionStore. Unless the device trust
+// is forgotten, the trust token is saved on its own so the next login can skip 2FA.
+func (c *Client) revokeStoredSession(opts LogoutOptions) error {
+	if c.SessionStore == nil {
+		return nil
+	}
+
+	if opts.ForgetTrust || c.trustToken == "" {
+		if err := c.SessionStore.Delete(c.Username); err != nil {
+			return fmt.Errorf("delete session: %w", err)
+		}
+		return nil
+	}
+
+	state := &SessionState{
+		Version:    sessionStateVersion,
+		Username:   c.Username,
+		SavedAt:    time.Now().UTC(),
+		Region:     c.region.Name,
+		TrustToken: c.trustToken,
+	}
+
+	if err := c.SessionStore.Save(c.Username, state); err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+
+	return nil
+}
+
+// clearCookies expires every session cookie in the client's cookie jar and checks that
+// none are left. It only goes through the Transport, so it works with any cookie jar.
+func (c *Client) clearCookies() error {
+	var urls []*url.URL
+	for _, rawURL := range c.sessionCookieURLs() {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		urls = append(urls, u)
+	}
+
+	for _, u := range urls {
+		var expired []*http.Cookie
+		for _, cookie := range c.HttpClient.GetCookies(u) {
+			expired = append(expired, expiredCookies(u, cookie)...)
+		}
+
+		if len(expired) > 0 {
+			c.HttpClient.SetCookies(u, expired)
+		}
+	}
+
+	for _, u := range urls {
+		if left := c.HttpClient.GetCookies(u); len(left) > 0 {
+			return fmt.Errorf("logout: %d cookies left in the jar for %s", len(left), u.Host)
+		}
+	}
+
+	return nil
+}
+
+// expiredCookies returns the cookies that delete cookie from a jar. A jar only deletes a
+// cookie set with the same domain and path; jars that do not report them, such as the
+// standard library's, get one for a host cookie and one for a domain cookie of u.
+func expiredCookies(u *url.URL, cookie *http.Cookie) []*http.Cookie {
+	expire := func(domain, path string) *http.Cookie {
+		return &http.Cookie{
+			Name:    cookie.Name,
+			Value:   "deleted", // the tls-client jar drops cookies without a value
+			Domain:  domain,
+			Path:    path,
+			MaxAge:  -1,
+			Expires: time.Unix(1, 0),
+		}
+	}
+
+	if cookie.Domain != "" || cookie.Path != "" {
+		return []*http.Cookie{expire(cookie.Domain, cookie.Path)}
+	}
+
+	return []*http.Cookie{expire(u.Hostname(), "/"), expire("", "/")}
+}
@@ -1,7 +1,7 @@
 package icloud
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,7 +13,15 @@ import (
 
 // * RetrieveMailInbox() retrieves the user's inbox from their iCloud account, maxResults is the maximum number of emails to retrieve, beforeTs is the timestamp to retrieve emails before
 func (c *Client) RetrieveMailInbox(maxResults, beforeTs int) (MailInboxResp, error) {
-	body, err := c.reqRetrieveMailInbox(maxResults, beforeTs)
+	return c.RetrieveMailInboxContext(context.Background(), maxResults, beforeTs)
+}
+
+// RetrieveMailInboxContext is like RetrieveMailInbox but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) RetrieveMailInboxContext(ctx context.Context, maxResults, beforeTs int) (_ MailInboxResp, err error) {
+	ctx, op := c.startOperation(ctx, "mail.Inbox")
+	defer func() { op.end(err) }()
+
+	body, err := c.reqRetrieveMailInbox(ctx, maxResults, beforeTs)
 	if err != nil {
 		return MailInboxResp{}, err
 	}
@@ -29,7 +37,15 @@ func (c *Client) RetrieveMailInbox(maxResults, beforeTs int) (MailInboxResp, err
 
 // * GetMessage() retrieves the message metadata from the user's inbox using the threadId
 func (c *Client) GetMessageMetadata(threadId string) (MessageMetadata, error) {
-	body, err := c.reqGetMessageMetadata(threadId)
+	return c.GetMessageMetadataContext(context.Background(), threadId)
+}
+
+// GetMessageMetadataContext is like GetMessageMetadata but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) GetMessageMetadataContext(ctx context.Context, threadId string) (_ MessageMetadata, err error) {
+	ctx, op := c.startOperation(ctx, "mail.GetMetadata")
+	defer func() { op.end(err) }()
+
+	body, err := c.reqGetMessageMetadata(ctx, threadId)
 	if err != nil {
 		return MessageMetadata{}, err
 	}
@@ -58,7 +74,15 @@ func (c *Client) GetMessageMetadata(threadId string) (MessageMetadata, error) {
 
 // * GetMessage() retrieves the message from the user's inbox using the uid, and returns the full body html of the email
 func (c *Client) GetMessage(uid string) (Message, error) {
-	body, err := c.reqGetMessage(uid)
+	return c.GetMessageContext(context.Background(), uid)
+}
+
+// GetMessageContext is like GetMessage but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) GetMessageContext(ctx context.Context, uid string) (_ Message, err error) {
+	ctx, op := c.startOperation(ctx, "mail.GetMessage")
+	defer func() { op.end(err) }()
+
+	body, err := c.reqGetMessage(ctx, uid)
 	if err != nil {
 		return Message{}, err
 	}
@@ -74,7 +98,15 @@ func (c *Client) GetMessage(uid string) (Message, error) {
 
 // * DeleteMail() deletes an email from the user's inbox using the uid
 func (c *Client) DeleteMail(uid string) (bool, error) {
-	body, err := c.reqMailDelete(uid)
+	return c.DeleteMailContext(context.Background(), uid)
+}
+
+// DeleteMailContext is like DeleteMail but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) DeleteMailContext(ctx context.Context, uid string) (_ bool, err error) {
+	ctx, op := c.startOperation(ctx, "mail.Delete")
+	defer func() { op.end(err) }()
+
+	body, err := c.reqMailDelete(ctx, uid)
 	if err != nil {
 		return false, err
 	}
@@ -87,7 +119,15 @@ func (c *Client) DeleteMail(uid string) (bool, error) {
 
 // * DraftMail() drafts and saves an email to the user's draft folder. fromName is the name of the sender, fromEmail is the email of the sender, toName is the name of the recipient, toEmail is the email of the recipient, subject is the subject of the email, textBody is the text body of the email, body is the full html body of the email
 func (c *Client) DraftMail(fromEmail, toEmail, subject, textBody, body string) (string, error) {
-	body, err := c.reqMailDraft(fromEmail, toEmail, subject, textBody, body)
+	return c.DraftMailContext(context.Background(), fromEmail, toEmail, subject, textBody, body)
+}
+
+// DraftMailContext is like DraftMail but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) DraftMailContext(ctx context.Context, fromEmail, toEmail, subject, textBody, body string) (_ string, err error) {
+	ctx, op := c.startOperation(ctx, "mail.Draft")
+	defer func() { op.end(err) }()
+
+	body, err = c.reqMailDraft(ctx, fromEmail, toEmail, subject, textBody, body)
 	if err != nil {
 		return "", err
 	}
@@ -96,47 +136,50 @@ func (c *Client) DraftMail(fromEmail, toEmail, subject, textBody, body string) (
 
 	// since gjosn.Get returns an empty string if not found, we can use this to check if the email was successfully drafted
 	if uid == "" {
-		return "", errors.New("failed to draft email")
+		return "", newAPIError(mailDraft, 200, []byte(body))
 	}
 
 	return uid, nil
 }
 
-// * SendDraft() sends an email from the user's draft folder using the uid
+// * SendDraft() sends an email from the user's draft folder using the uid. If the draft can not be sent an *APIError is returned
 func (c *Client) SendDraft(uid string) (bool, error) {
-	resp, err := c.reqSendDraft(uid)
+	return c.SendDraftContext(context.Background(), uid)
+}
+
+// SendDraftContext is like SendDraft but aborts when ctx is cancelled or its deadline expires.
+func (c *Client) SendDraftContext(ctx context.Context, uid string) (_ bool, err error) {
+	ctx, op := c.startOperation(ctx, "mail.SendDraft")
+	defer func() { op.end(err) }()
+
+	resp, err := c.reqSendDraft(ctx, uid)
 	if err != nil {
 		return false, err
 	}
 
 	// * 404 is the default response for failure/draft not found
-	return resp.StatusCode == 200, nil
+	if _, err := readMailResponse(mailSend, resp); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
-func (c *Client) reqRetrieveMailInbox(maxResults, beforeTs int) (string, error) {
+func (c *Client) reqRetrieveMailInbox(ctx context.Context, maxResults, beforeTs int) (string, error) {
 	beforeTsStr := fmt.Sprintf("%d", beforeTs)
 
 	if beforeTs == 0 {
 		beforeTsStr = ""
 	}
 
-	body := bytes.NewReader([]byte(fmt.Sprintf(`{"responseType":"THREAD_DIGEST","includeFolderStatus":false,"maxResults":%d,"before":"%s","sessionHeaders":{"folder":"INBOX","condstore":1,"qresync":1,"threadmode":1}}`, maxResults, beforeTsStr)))
+	data := json.RawMessage(fmt.Sprintf(`{"responseType":"THREAD_DIGEST","includeFolderStatus":false,"maxResults":%d,"before":"%s","sessionHeaders":{"folder":"INBOX","condstore":1,"qresync":1,"threadmode":1}}`, maxResults, beforeTsStr))
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[mailInbox], body)
+	resp, err := c.sendJSON(ctx, mailInbox, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readMailResponse(mailInbox, resp)
 	if err != nil {
 		return "", err
 	}
@@ -144,24 +187,15 @@ func (c *Client) reqRetrieveMailInbox(maxResults, beforeTs int) (string, error)
 	return string(bodyBytes), nil
 }
 
-func (c *Client) reqGetMessageMetadata(threadId string) (string, error) {
-	body := bytes.NewReader([]byte(`{"threadId":"` + threadId + `","includeLabelIds":false,"sessionHeaders":{"folder":"INBOX","condstore":1,"qresync":1,"threadmode":1}}`))
-
-	req, err := http.NewRequest(http.MethodPost, endpoints[mailMetadataGet], body)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
+func (c *Client) reqGetMessageMetadata(ctx context.Context, threadId string) (string, error) {
+	data := json.RawMessage(`{"threadId":"` + threadId + `","includeLabelIds":false,"sessionHeaders":{"folder":"INBOX","condstore":1,"qresync":1,"threadmode":1}}`)
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, mailMetadataGet, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readMailResponse(mailMetadataGet, resp)
 	if err != nil {
 		return "", err
 	}
@@ -169,24 +203,15 @@ func (c *Client) reqGetMessageMetadata(threadId string) (string, error) {
 	return string(bodyBytes), nil
 }
 
-func (c *Client) reqGetMessage(uid string) (string, error) {
-	body := bytes.NewReader([]byte(`{"uid":"` + uid + `","parts":["2.1"],"dontMarkAsRead":true,"sessionHeaders":{"folder":"INBOX","condstore":1,"qresync":1,"threadmode":1}}`))
-
-	req, err := http.NewRequest(http.MethodPost, endpoints[mailGet], body)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
+func (c *Client) reqGetMessage(ctx context.Context, uid string) (string, error) {
+	data := json.RawMessage(`{"uid":"` + uid + `","parts":["2.1"],"dontMarkAsRead":true,"sessionHeaders":{"folder":"INBOX","condstore":1,"qresync":1,"threadmode":1}}`)
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, mailGet, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readMailResponse(mailGet, resp)
 	if err != nil {
 		return "", err
 	}
@@ -194,24 +219,15 @@ func (c *Client) reqGetMessage(uid string) (string, error) {
 	return string(bodyBytes), nil
 }
 
-func (c *Client) reqMailDelete(uid string) (string, error) {
-	body := bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"delete","params":{"folder":"folder:INBOX","uids":["` + uid + `"],"rollbackslot":"0.0"}}`))
+func (c *Client) reqMailDelete(ctx context.Context, uid string) (string, error) {
+	data := json.RawMessage(`{"jsonrpc":"2.0","method":"delete","params":{"folder":"folder:INBOX","uids":["` + uid + `"],"rollbackslot":"0.0"}}`)
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[mailDelete], body)
+	resp, err := c.sendJSON(ctx, mailDelete, http.MethodPost, nil, data)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readMailResponse(mailDelete, resp)
 	if err != nil {
 		return "", err
 	}
@@ -220,7 +236,7 @@ func (c *Client) reqMailDelete(uid string) (string, error) {
 }
 
 // todo: support attachments
-func (c *Client) reqMailDraft(fromEmail, toEmail, subject, textBody, body string) (string, error) {
+func (c *Client) reqMailDraft(ctx context.Context, fromEmail, toEmail, subject, textBody, body string) (string, error) {
 	payload := MailDraftReq{
 		Jsonrpc: "2.0",
 		Method:  "saveDraft",
@@ -235,30 +251,12 @@ func (c *Client) reqMailDraft(fromEmail, toEmail, subject, textBody, body string
 		},
 	}
 
-	var buf bytes.Buffer
-	encoder := json.NewEncoder(&buf)
-	encoder.SetEscapeHTML(false) // Disable HTML escaping
-	if err := encoder.Encode(payload); err != nil {
-		return "", err
-	}
-
-	jsonBytes := buf.Bytes()
-
-	req, err := http.NewRequest(http.MethodPost, endpoints[mailDraft], bytes.NewReader(jsonBytes))
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
-
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.sendJSON(ctx, mailDraft, http.MethodPost, nil, payload)
 	if err != nil {
 		return "", err
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := readMailResponse(mailDraft, resp)
 	if err != nil {
 		return "", err
 	}
@@ -266,22 +264,30 @@ func (c *Client) reqMailDraft(fromEmail, toEmail, subject, textBody, body string
 	return string(bodyBytes), nil
 }
 
-func (c *Client) reqSendDraft(uid string) (*http.Response, error) {
-	body := bytes.NewReader([]byte(`{"messageGuid":"Drafts/` + uid + `","sessionHeaders":{"folder":null,"modseq":null,"threadmodseq":null,"condstore":1,"qresync":1,"threadmode":1}}`))
+func (c *Client) reqSendDraft(ctx context.Context, uid string) (*http.Response, error) {
+	data := json.RawMessage(`{"messageGuid":"Drafts/` + uid + `","sessionHeaders":{"folder":null,"modseq":null,"threadmodseq":null,"condstore":1,"qresync":1,"threadmode":1}}`)
 
-	req, err := http.NewRequest(http.MethodPost, endpoints[mailSend], body)
+	resp, err := c.sendJSON(ctx, mailSend, http.MethodPost, nil, data)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set(HdrContentType, "application/json")
-	req.Header.Set("origin", "https://www.icloud.com")
-	req.Header.Set("accept", "*/*")
+	return resp, nil
+}
 
-	resp, err := c.HttpClient.Do(req)
+// readMailResponse reads a mail service response. The JSON-RPC endpoints answer failed calls
+// with a 200 status and an "error" object, so the body is checked as well as the status.
+func readMailResponse(ep endpoint, resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return resp, nil
+	if resp.StatusCode != 200 || gjson.GetBytes(bodyBytes, "error").IsObject() {
+		return nil, newAPIError(ep, resp.StatusCode, bodyBytes)
+	}
+
+	return bodyBytes, nil
 }
@@ -0,0 +1,585 @@
+package icloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPoolKeepAlive   = 5 * time.Minute
+	defaultPoolRateBackoff = 15 * time.Minute
+)
+
+// AccountHealth is the state a Pool last saw an account in.
+type AccountHealth int
+
+const (
+	// HealthUnknown means the account has not been logged in yet.
+	HealthUnknown AccountHealth = iota
+	// HealthValid means the account has a live session.
+	HealthValid
+	// HealthExpired means the session died; the account logs in again on next use.
+	HealthExpired
+	// HealthNeedsTwoFactor means Apple asked for a two-factor code that could not be given.
+	HealthNeedsTwoFactor
+	// HealthLocked means Apple locked the Apple ID.
+	HealthLocked
+	// HealthRateLimited means Apple throttled the account; it is skipped for a while.
+	HealthRateLimited
+	// HealthFailed means the login was rejected, e.g. because of a wrong password.
+	HealthFailed
+)
+
+func (h AccountHealth) String() string {
+	switch h {
+	case HealthUnknown:
+		return "unknown"
+	case HealthValid:
+		return "valid"
+	case HealthExpired:
+		return "expired"
+	case HealthNeedsTwoFactor:
+		return "needs 2fa"
+	case HealthLocked:
+		return "locked"
+	case HealthRateLimited:
+		return "rate limited"
+	case HealthFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("AccountHealth(%d)", int(h))
+}
+
+// NeedsAttention reports whether the account stays unusable until someone acts on it,
+// e.g. by entering a 2FA code, unlocking the Apple ID or fixing the password.
+func (h AccountHealth) NeedsAttention() bool {
+	return h == HealthNeedsTwoFactor || h == HealthLocked || h == HealthFailed
+}
+
+// PoolAccount is an Apple ID managed by a Pool.
+type PoolAccount struct {
+	// ID identifies the account in the pool. Defaults to Username.
+	ID string
+
+	Username string
+	Password string
+
+	// TwoFactor drives two-factor authentication for the account. Without one, an account
+	// that needs a code is marked HealthNeedsTwoFactor instead.
+	TwoFactor TwoFactorHandler
+
+	// Options are applied to the account's client after PoolOptions.ClientOptions.
+	Options []Option
+}
+
+// AccountStatus is a snapshot of an account's health in a Pool.
+type AccountStatus struct {
+	ID       string
+	Username string
+	Health   AccountHealth
+	// Err is the error that put the account in its current state, if any.
+	Err error
+	// Since is when the account entered its current state.
+	Since time.Time
+	// RetryAt is when a rate limited account is used again.
+	RetryAt time.Time
+}
+
+// PoolEvent is passed to PoolOptions.OnEvent whenever an account's health changes.
+type PoolEvent struct {
+	AccountID string
+	Health    AccountHealth
+	Previous  AccountHealth
+	Err       error
+	Time      time.Time
+}
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// ClientOptions are applied to every client the pool creates, e.g. WithSessionStore so
+	// logins resume saved sessions and skip 2FA.
+	ClientOptions []Option
+
+	// KeepAliveInterval is how often each logged in account validates its session.
+	// Defaults to 5 minutes.
+	KeepAliveInterval time.Duration
+
+	// RateLimitBackoff is how long an account Apple answered 429 for is skipped. Accounts
+	// held back by the client-side RateLimiter are skipped until it has capacity again.
+	// Defaults to 15 minutes.
+	RateLimitBackoff time.Duration
+
+	// OnEvent, when set, is called on every health change. Events where
+	// Health.NeedsAttention() is true mean the account needs human attention. It is
+	// called from the pool's goroutines and must not block for long.
+	OnEvent func(PoolEvent)
+}
+
+// * Pool owns one Client per Apple ID. Accounts are logged in lazily on first use, keep
+// their session alive in the background and are handed out by ID or round-robin. A Pool is
+// safe for concurrent use.
+type Pool struct {
+	opts PoolOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	accounts map[string]*poolEntry
+	order    []string
+	next     int
+	closed   bool
+}
+
+type poolEntry struct {
+	account PoolAccount
+
+	// loginMu serializes logins of the account; mu guards the fields below.
+	loginMu sync.Mutex
+	mu      sync.Mutex
+
+	client    *Client
+	health    AccountHealth
+	err       error
+	since     time.Time
+	retryAt   time.Time
+	keepAlive context.CancelFunc
+}
+
+// NewPool returns a pool for accounts. No account is logged in until it is first used.
+func NewPool(accounts []PoolAccount, opts PoolOptions) (*Pool, error) {
+	if opts.KeepAliveInterval <= 0 {
+		opts.KeepAliveInterval = defaultPoolKeepAlive
+	}
+	if opts.RateLimitBackoff <= 0 {
+		opts.RateLimitBackoff = defaultPoolRateBackoff
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &Pool{
+		opts:     opts,
+		ctx:      ctx,
+		cancel:   cancel,
+		accounts: make(map[string]*poolEntry),
+	}
+
+	for _, account := range accounts {
+		if err := p.Add(account); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Add adds an account to the pool. It is logged in on first use.
+func (p *Pool) Add(account PoolAccount) error {
+	if account.Username == "" {
+		return errors.New("pool account has no username")
+	}
+	if account.ID == "" {
+		account.ID = account.Username
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if _, ok := p.accounts[account.ID]; ok {
+		return fmt.Errorf("pool account %q already exists", account.ID)
+	}
+
+	p.accounts[account.ID] = &poolEntry{account: account, since: time.Now()}
+	p.order = append(p.order, account.ID)
+
+	return nil
+}
+
+// Remove stops the account's keep-alive and drops it from the pool. The session is not
+// logged out, so it can still be resumed from a SessionStore.
+func (p *Pool) Remove(id string) error {
+	p.mu.Lock()
+	e, ok := p.accounts[id]
+	if ok {
+		delete(p.accounts, id)
+		for i, orderID := range p.order {
+			if orderID == id {
+				p.order = append(p.order[:i], p.order[i+1:]...)
+				break
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s: %w", id, ErrAccountNotFound)
+	}
+
+	e.mu.Lock()
+	e.stopKeepAlive()
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Get returns the client for the account with the given ID, logging it in first if needed.
+// Accounts that need attention or are rate limited return an error wrapping the failure
+// that put them in that state.
+func (p *Pool) Get(ctx context.Context, id string) (*Client, error) {
+	p.mu.Lock()
+	e, ok := p.accounts[id]
+	closed := p.closed
+	p.mu.Unlock()
+
+	if closed {
+		return nil, ErrPoolClosed
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", id, ErrAccountNotFound)
+	}
+
+	return p.client(ctx, e)
+}
+
+// Next hands out the accounts in round-robin order, e.g. to spread HME generation over
+// all of them. Accounts that are not usable are skipped; ErrNoHealthyAccount is returned
+// when none is left.
+func (p *Pool) Next(ctx context.Context) (string, *Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return "", nil, ErrPoolClosed
+	}
+
+	entries := make([]*poolEntry, 0, len(p.order))
+	for i := range p.order {
+		entries = append(entries, p.accounts[p.order[(p.next+i)%len(p.order)]])
+	}
+	p.mu.Unlock()
+
+	for _, e := range entries {
+		client, err := p.client(ctx, e)
+		if err == nil {
+			p.advance(e.account.ID)
+			return e.account.ID, client, nil
+		}
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+	}
+
+	return "", nil, ErrNoHealthyAccount
+}
+
+// advance makes Next continue with the account after id.
+func (p *Pool) advance(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, orderID := range p.order {
+		if orderID == id {
+			p.next = i + 1
+			return
+		}
+	}
+}
+
+// ReserveHME reserves a Hide My Email address on the next healthy account in round-robin
+// order. An account that fails is marked accordingly and the next one is tried.
+func (p *Pool) ReserveHME(ctx context.Context, label, note string) (accountID, email string, err error) {
+	p.mu.Lock()
+	attempts := len(p.order)
+	p.mu.Unlock()
+
+	for i := 0; i < attempts; i++ {
+		id, client, err := p.Next(ctx)
+		if err != nil {
+			return "", "", err
+		}
+
+		email, err := client.ReserveHMEContext(ctx, label, note)
+		if err == nil {
+			return id, email, nil
+		}
+
+		p.Report(id, err)
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
+	}
+
+	return "", "", ErrNoHealthyAccount
+}
+
+// Report records the result of a call made with an account's client. Errors that say
+// something about the account, such as rate limiting or an expired session, update its
+// health; other errors are ignored.
+func (p *Pool) Report(id string, err error) {
+	p.mu.Lock()
+	e, ok := p.accounts[id]
+	p.mu.Unlock()
+
+	if !ok || err == nil {
+		return
+	}
+
+	if health, ok := healthFromError(err); ok {
+		p.setHealth(e, health, err)
+	}
+}
+
+// Reset marks the account as not logged in, so the next Get tries to log in again. Use it
+// once the problem behind HealthNeedsTwoFactor, HealthLocked or HealthFailed is fixed.
+func (p *Pool) Reset(id string) error {
+	p.mu.Lock()
+	e, ok := p.accounts[id]
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s: %w", id, ErrAccountNotFound)
+	}
+
+	p.setHealth(e, HealthUnknown, nil)
+	return nil
+}
+
+// Status returns the health of every account, in the order they were added.
+func (p *Pool) Status() []AccountStatus {
+	p.mu.Lock()
+	entries := make([]*poolEntry, 0, len(p.order))
+	for _, id := range p.order {
+		entries = append(entries, p.accounts[id])
+	}
+	p.mu.Unlock()
+
+	statuses := make([]AccountStatus, 0, len(entries))
+	for _, e := range entries {
+		statuses = append(statuses, e.status())
+	}
+
+	return statuses
+}
+
+// Health returns the health of the account with the given ID.
+func (p *Pool) Health(id string) (AccountStatus, error) {
+	p.mu.Lock()
+	e, ok := p.accounts[id]
+	p.mu.Unlock()
+
+	if !ok {
+		return AccountStatus{}, fmt.Errorf("%s: %w", id, ErrAccountNotFound)
+	}
+
+	return e.status(), nil
+}
+
+// Close stops every keep-alive and waits for them to return. Sessions are not logged
+// out. The pool can not be used afterwards.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	p.cancel()
+	p.wg.Wait()
+}
+
+// client returns e's client, logging it in if it has no live session.
+func (p *Pool) client(ctx context.Context, e *poolEntry) (*Client, error) {
+	e.loginMu.Lock()
+	defer e.loginMu.Unlock()
+
+	e.mu.Lock()
+	health, err, client, retryAt := e.health, e.err, e.client, e.retryAt
+	e.mu.Unlock()
+
+	switch {
+	case health == HealthValid:
+		return client, nil
+	case health == HealthRateLimited && time.Now().Before(retryAt):
+		return nil, fmt.Errorf("%s: %s until %s: %w", e.account.ID, health, retryAt.Format(time.RFC3339), err)
+	case health.NeedsAttention():
+		return nil, fmt.Errorf("%s: %s: %w", e.account.ID, health, err)
+	case health == HealthRateLimited && client != nil:
+		// the back-off is over. The keep-alive returned if it was the one Apple throttled,
+		// so it is started again before the session expires.
+		p.setHealth(e, HealthValid, nil)
+		p.startKeepAlive(e, client)
+		return client, nil
+	}
+
+	if client == nil {
+		opts := append([]Option{WithCredentials(e.account.Username, e.account.Password)}, p.opts.ClientOptions...)
+		opts = append(opts, e.account.Options...)
+
+		client, err = NewClientWithOptions(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		e.mu.Lock()
+		e.client = client
+		e.mu.Unlock()
+	}
+
+	if err := client.LoginWithHandlerContext(ctx, e.account.TwoFactor); err != nil {
+		if health, ok := healthFromError(err); ok {
+			p.setHealth(e, health, err)
+		}
+		return nil, fmt.Errorf("%s: %w", e.account.ID, err)
+	}
+
+	p.setHealth(e, HealthValid, nil)
+	p.startKeepAlive(e, client)
+
+	return client, nil
+}
+
+// startKeepAlive runs KeepAlive for the account until the pool is closed or the session
+// dies, in which case the account is marked expired and logs in again on next use.
+func (p *Pool) startKeepAlive(e *poolEntry, client *Client) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.wg.Add(1)
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(p.ctx)
+
+	e.mu.Lock()
+	e.stopKeepAlive()
+	e.keepAlive = cancel
+	e.mu.Unlock()
+
+	go func() {
+		defer p.wg.Done()
+		defer cancel()
+
+		err := client.KeepAlive(ctx, p.opts.KeepAliveInterval)
+
+		health, ok := healthFromError(err)
+		if !ok {
+			health = HealthExpired
+		}
+
+		// a keep-alive that was replaced or stopped must not overwrite the newer state
+		e.mu.Lock()
+		if ctx.Err() != nil {
+			e.mu.Unlock()
+			return
+		}
+		event, changed := e.setHealth(health, err, p.opts.RateLimitBackoff)
+		e.mu.Unlock()
+
+		p.emit(event, changed)
+	}()
+}
+
+// setHealth moves e to health and emits an event if that changed its state.
+func (p *Pool) setHealth(e *poolEntry, health AccountHealth, err error) {
+	e.mu.Lock()
+	event, changed := e.setHealth(health, err, p.opts.RateLimitBackoff)
+	e.mu.Unlock()
+
+	p.emit(event, changed)
+}
+
+func (p *Pool) emit(event PoolEvent, changed bool) {
+	if changed && p.opts.OnEvent != nil {
+		p.opts.OnEvent(event)
+	}
+}
+
+// setHealth records the new state and returns the event for it, and whether the state
+// changed. The caller must hold e.mu.
+func (e *poolEntry) setHealth(health AccountHealth, err error, backoff time.Duration) (PoolEvent, bool) {
+	now := time.Now()
+
+	previous := e.health
+	e.health = health
+	e.err = err
+	if health == HealthRateLimited {
+		e.retryAt = now.Add(backoff)
+
+		// the client-side limiter knows exactly when capacity returns
+		var rlErr *RateLimitError
+		if errors.As(err, &rlErr) {
+			e.retryAt = rlErr.RetryAt
+		}
+	}
+	if health != HealthValid && health != HealthRateLimited {
+		// only a live session is worth keeping alive
+		e.stopKeepAlive()
+	}
+
+	changed := previous != health
+	if changed {
+		e.since = now
+	}
+
+	return PoolEvent{
+		AccountID: e.account.ID,
+		Health:    health,
+		Previous:  previous,
+		Err:       err,
+		Time:      now,
+	}, changed
+}
+
+// stopKeepAlive cancels the account's keep-alive, if one is running. The caller must hold e.mu.
+func (e *poolEntry) stopKeepAlive() {
+	if e.keepAlive != nil {
+		e.keepAlive()
+		e.keepAlive = nil
+	}
+}
+
+func (e *poolEntry) status() AccountStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := AccountStatus{
+		ID:       e.account.ID,
+		Username: e.account.Username,
+		Health:   e.health,
+		Err:      e.err,
+		Since:    e.since,
+	}
+	if e.health == HealthRateLimited {
+		status.RetryAt = e.retryAt
+	}
+
+	return status
+}
+
+// healthFromError maps an error from Login or a service call to the account health it
+// implies. It reports false for errors that say nothing about the account, such as network
+// failures or a cancelled context.
+func healthFromError(err error) (AccountHealth, bool) {
+	var apiErr *APIError
+
+	switch {
+	case errors.Is(err, ErrAccountLocked):
+		return HealthLocked, true
+	case errors.Is(err, ErrTwoFactorRequired), errors.Is(err, ErrNoTwoFactorMethod), errors.Is(err, ErrIncorrectOTP):
+		return HealthNeedsTwoFactor, true
+	case errors.Is(err, ErrIncorrectUsernamePassword), errors.Is(err, ErrSeverErrorOrInvalidCreds), errors.Is(err, ErrRequiredPrivacyAck):
+		return HealthFailed, true
+	case errors.Is(err, ErrSessionExpired), errors.Is(err, ErrFindMySessionExpired):
+		return HealthExpired, true
+	case errors.Is(err, ErrRateLimited), errors.As(err, &apiErr) && apiErr.StatusCode == 429:
+		return HealthRateLimited, true
+	}
+
+	return HealthUnknown, false
+}
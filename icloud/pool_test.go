@@ -0,0 +1,294 @@
+package icloud_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+)
+
+// poolEvents collects the events a Pool emits.
+type poolEvents struct {
+	mu     sync.Mutex
+	events []icloud.PoolEvent
+}
+
+func (e *poolEvents) add(event icloud.PoolEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+// healths returns the health transitions of the account, in order.
+func (e *poolEvents) healths(id string) []icloud.AccountHealth {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var healths []icloud.AccountHealth
+	for _, event := range e.events {
+		if event.AccountID == id {
+			healths = append(healths, event.Health)
+		}
+	}
+	return healths
+}
+
+func newTestPool(t *testing.T, srv *icloudtest.Server, opts icloud.PoolOptions, accounts ...icloud.PoolAccount) (*icloud.Pool, *poolEvents) {
+	t.Helper()
+
+	events := &poolEvents{}
+	opts.ClientOptions = srv.ClientOptions()
+	opts.OnEvent = events.add
+
+	pool, err := icloud.NewPool(accounts, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool, events
+}
+
+// waitForHealth polls the account until it reaches want.
+func waitForHealth(t *testing.T, pool *icloud.Pool, id string, want icloud.AccountHealth) icloud.AccountStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := pool.Health(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Health == want {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is %s, want %s", id, status.Health, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func addPoolAccounts(t *testing.T, srv *icloudtest.Server, accounts ...icloudtest.Account) {
+	t.Helper()
+
+	for _, acct := range accounts {
+		acct.Password = "password"
+		acct.ICloudPlus = true
+		if err := srv.AddAccount(acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+	addPoolAccounts(t, srv, icloudtest.Account{AppleID: "one@icloud.com"}, icloudtest.Account{AppleID: "two@icloud.com"})
+
+	pool, events := newTestPool(t, srv, icloud.PoolOptions{},
+		icloud.PoolAccount{Username: "one@icloud.com", Password: "password"},
+		icloud.PoolAccount{Username: "two@icloud.com", Password: "password"},
+	)
+
+	for _, status := range pool.Status() {
+		if status.Health != icloud.HealthUnknown {
+			t.Errorf("%s is %s before first use, want unknown", status.ID, status.Health)
+		}
+	}
+	if calls := srv.Calls("auth"); calls != 0 {
+		t.Errorf("pool made %d auth calls before first use", calls)
+	}
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		id, _, err := pool.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+
+	want := []string{"one@icloud.com", "two@icloud.com", "one@icloud.com", "two@icloud.com"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Next handed out %v, want %v", got, want)
+		}
+	}
+
+	if healths := events.healths("one@icloud.com"); len(healths) != 1 || healths[0] != icloud.HealthValid {
+		t.Errorf("one@icloud.com went through %v, want a single change to valid", healths)
+	}
+}
+
+func TestPoolNeedsAttention(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+	addPoolAccounts(t, srv,
+		icloudtest.Account{AppleID: "locked@icloud.com", Locked: true},
+		icloudtest.Account{AppleID: "2fa@icloud.com", TwoFactor: true},
+		icloudtest.Account{AppleID: "wrong@icloud.com"},
+	)
+
+	pool, events := newTestPool(t, srv, icloud.PoolOptions{},
+		icloud.PoolAccount{Username: "locked@icloud.com", Password: "password"},
+		icloud.PoolAccount{Username: "2fa@icloud.com", Password: "password"},
+		icloud.PoolAccount{Username: "wrong@icloud.com", Password: "not the password"},
+	)
+
+	if _, _, err := pool.Next(context.Background()); !errors.Is(err, icloud.ErrNoHealthyAccount) {
+		t.Fatalf("Next = %v, want ErrNoHealthyAccount", err)
+	}
+
+	for id, want := range map[string]icloud.AccountHealth{
+		"locked@icloud.com": icloud.HealthLocked,
+		"2fa@icloud.com":    icloud.HealthNeedsTwoFactor,
+		"wrong@icloud.com":  icloud.HealthFailed,
+	} {
+		status, err := pool.Health(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Health != want || status.Err == nil {
+			t.Errorf("%s is %s (%v), want %s with its error", id, status.Health, status.Err, want)
+		}
+		if !status.Health.NeedsAttention() {
+			t.Errorf("%s does not need attention", status.Health)
+		}
+		if healths := events.healths(id); len(healths) != 1 || healths[0] != want {
+			t.Errorf("%s went through %v, want a single change to %s", id, healths, want)
+		}
+	}
+
+	// an account that needs attention is not logged in again until it is reset
+	calls := srv.Calls("auth.init")
+	if _, err := pool.Get(context.Background(), "locked@icloud.com"); !errors.Is(err, icloud.ErrAccountLocked) {
+		t.Errorf("Get of a locked account = %v, want ErrAccountLocked", err)
+	}
+	if srv.Calls("auth.init") != calls {
+		t.Error("Get signed a locked account in again")
+	}
+
+	addPoolAccounts(t, srv, icloudtest.Account{AppleID: "locked@icloud.com"})
+	if err := pool.Reset("locked@icloud.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Get(context.Background(), "locked@icloud.com"); err != nil {
+		t.Fatalf("Get after Reset: %v", err)
+	}
+
+	want := []icloud.AccountHealth{icloud.HealthLocked, icloud.HealthUnknown, icloud.HealthValid}
+	if healths := events.healths("locked@icloud.com"); len(healths) != len(want) || healths[2] != want[2] {
+		t.Errorf("locked@icloud.com went through %v, want %v", healths, want)
+	}
+}
+
+func TestPoolRateLimitedKeepAliveResumes(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+	addPoolAccounts(t, srv, icloudtest.Account{AppleID: "user@icloud.com"})
+
+	pool, events := newTestPool(t, srv, icloud.PoolOptions{
+		KeepAliveInterval: 10 * time.Millisecond,
+		RateLimitBackoff:  100 * time.Millisecond,
+	}, icloud.PoolAccount{Username: "user@icloud.com", Password: "password"})
+
+	srv.InjectFailure(icloudtest.Failure{Endpoint: "auth.validate", Status: 429, Times: 1})
+
+	if _, err := pool.Get(context.Background(), "user@icloud.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForHealth(t, pool, "user@icloud.com", icloud.HealthRateLimited)
+	if status.RetryAt.IsZero() {
+		t.Error("a rate limited account has no RetryAt")
+	}
+	if _, err := pool.Get(context.Background(), "user@icloud.com"); err == nil {
+		t.Error("Get handed out a rate limited account during its back-off")
+	}
+
+	time.Sleep(time.Until(status.RetryAt))
+	if _, err := pool.Get(context.Background(), "user@icloud.com"); err != nil {
+		t.Fatalf("Get after the back-off: %v", err)
+	}
+
+	// the keep-alive that got the 429 returned, so it has to be running again
+	validated := srv.Calls("auth.validate")
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.Calls("auth.validate") < validated+3 {
+		if time.Now().After(deadline) {
+			t.Fatal("the session is not kept alive after the back-off")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	want := []icloud.AccountHealth{icloud.HealthValid, icloud.HealthRateLimited, icloud.HealthValid}
+	healths := events.healths("user@icloud.com")
+	if len(healths) != len(want) {
+		t.Fatalf("went through %v, want %v", healths, want)
+	}
+	for i := range want {
+		if healths[i] != want[i] {
+			t.Fatalf("went through %v, want %v", healths, want)
+		}
+	}
+}
+
+func TestPoolExpiredSessionLogsInAgain(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+	addPoolAccounts(t, srv, icloudtest.Account{AppleID: "user@icloud.com"})
+
+	pool, _ := newTestPool(t, srv, icloud.PoolOptions{KeepAliveInterval: 10 * time.Millisecond},
+		icloud.PoolAccount{Username: "user@icloud.com", Password: "password"})
+
+	if _, err := pool.Get(context.Background(), "user@icloud.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.ExpireSessions("user@icloud.com")
+	waitForHealth(t, pool, "user@icloud.com", icloud.HealthExpired)
+
+	logins := srv.Calls("auth.accountLogin")
+	client, err := pool.Get(context.Background(), "user@icloud.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Calls("auth.accountLogin") == logins {
+		t.Error("Get handed out an expired account without logging it in")
+	}
+	if _, err := client.RetrieveHMEList(); err != nil {
+		t.Errorf("the client does not work after logging in again: %v", err)
+	}
+	waitForHealth(t, pool, "user@icloud.com", icloud.HealthValid)
+}
+
+func TestPoolReserveHMEFailsOver(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+	addPoolAccounts(t, srv, icloudtest.Account{AppleID: "one@icloud.com"}, icloudtest.Account{AppleID: "two@icloud.com"})
+
+	pool, _ := newTestPool(t, srv, icloud.PoolOptions{},
+		icloud.PoolAccount{Username: "one@icloud.com", Password: "password"},
+		icloud.PoolAccount{Username: "two@icloud.com", Password: "password"},
+	)
+
+	srv.InjectFailure(icloudtest.Failure{Endpoint: "hme.reserve", Status: 429, Times: 1})
+
+	id, email, err := pool.ReserveHME(context.Background(), "label", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "two@icloud.com" || email == "" {
+		t.Errorf("reserved %q on %s, want an address on two@icloud.com", email, id)
+	}
+	if len(srv.HMEs("two@icloud.com")) != 1 {
+		t.Error("the address was not reserved on the server")
+	}
+
+	waitForHealth(t, pool, "one@icloud.com", icloud.HealthRateLimited)
+}
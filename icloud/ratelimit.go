@@ -0,0 +1,214 @@
+package icloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimits stay below the limits Apple is known to enforce: 5 Hide My Email
+// reservations per hour, Find My refreshes a few seconds apart, and few enough sign ins
+// that the account is not flagged.
+var DefaultRateLimits = map[string]Rate{
+	"hme.reserve": {Limit: 5, Per: time.Hour},
+	"findmy":      {Limit: 1, Per: 5 * time.Second, Burst: 3},
+	"auth.start":  {Limit: 10, Per: time.Hour, Burst: 3},
+}
+
+// Rate is a token bucket: Limit calls per Per on average, with up to Burst calls at once.
+type Rate struct {
+	Limit int
+	Per   time.Duration
+	// Burst is the bucket size. Defaults to Limit.
+	Burst int
+}
+
+// RateLimitMode decides what a call does when its bucket is empty.
+type RateLimitMode int
+
+const (
+	// RateLimitWait blocks until capacity returns, or fails with a *RateLimitError right
+	// away if the context would expire first.
+	RateLimitWait RateLimitMode = iota
+	// RateLimitFailFast fails with a *RateLimitError without waiting.
+	RateLimitFailFast
+)
+
+// RateLimitError is returned when a call is held back by the client-side RateLimiter.
+// It matches ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	// Account is the Apple ID the limit applies to.
+	Account string
+	// Limit is the key of the exhausted limit, e.g. "hme.reserve" or "findmy".
+	Limit string
+	// RetryAt is when the call can be made again.
+	RetryAt time.Time
+}
+
+// RetryAfter returns how long until the call can be made again.
+func (e *RateLimitError) RetryAfter() time.Duration {
+	if d := time.Until(e.RetryAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (e *RateLimitError) Error() string {
+	d := e.RetryAfter()
+	if d >= time.Second {
+		d = d.Round(time.Second)
+	} else {
+		d = d.Round(time.Millisecond)
+	}
+
+	return fmt.Sprintf("%s: %s for %s, capacity returns in %s", e.Limit, ErrRateLimited, e.Account, d)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// * RateLimiter holds token buckets per account and per limit key. A key is either an
+// endpoint name such as "hme.reserve", or a service name such as "hme", "mail", "findmy",
+// "contacts", "account" or "auth" that covers all of its endpoints. A call takes a token
+// from every bucket that matches it.
+//
+// Share one RateLimiter between all clients, e.g. through PoolOptions.ClientOptions, so
+// clients for the same account draw from the same buckets.
+type RateLimiter struct {
+	mode  RateLimitMode
+	rates map[string]Rate
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+
+	// now and sleep are time.Now and sleepContext, replaced in tests.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter enforcing rates, e.g. DefaultRateLimits.
+func NewRateLimiter(mode RateLimitMode, rates map[string]Rate) (*RateLimiter, error) {
+	l := &RateLimiter{
+		mode:    mode,
+		rates:   make(map[string]Rate, len(rates)),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+		sleep:   sleepContext,
+	}
+
+	for key, rate := range rates {
+		if rate.Limit <= 0 || rate.Per <= 0 {
+			return nil, fmt.Errorf("rate limit %q: Limit and Per must be positive", key)
+		}
+		if rate.Burst <= 0 {
+			rate.Burst = rate.Limit
+		}
+		l.rates[key] = rate
+	}
+
+	return l, nil
+}
+
+// wait takes a token for the account's call to ep, blocking or failing as l's mode says.
+func (l *RateLimiter) wait(ctx context.Context, account string, ep endpoint) error {
+	if l == nil {
+		return nil
+	}
+
+	keys := l.keys(ep)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	account = strings.ToLower(strings.TrimSpace(account))
+
+	for {
+		now := l.now()
+		delay, limit := l.take(account, keys, now)
+		if delay == 0 {
+			return nil
+		}
+
+		retryAt := now.Add(delay)
+		rlErr := &RateLimitError{Account: account, Limit: limit, RetryAt: retryAt}
+
+		if l.mode == RateLimitFailFast {
+			return rlErr
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return rlErr
+		}
+
+		if err := l.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// keys returns the configured limits that apply to ep.
+func (l *RateLimiter) keys(ep endpoint) []string {
+	var keys []string
+	for _, key := range []string{ep.String(), ep.service()} {
+		if _, ok := l.rates[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// take takes a token from every bucket in keys if all of them have one. Otherwise it takes
+// nothing and returns how long until the emptiest bucket refills, and its key.
+func (l *RateLimiter) take(account string, keys []string, now time.Time) (time.Duration, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		delay   time.Duration
+		limit   string
+		buckets = make([]*tokenBucket, 0, len(keys))
+	)
+
+	for _, key := range keys {
+		rate := l.rates[key]
+		perToken := rate.Per / time.Duration(rate.Limit)
+
+		b, ok := l.buckets[account+"\x00"+key]
+		if !ok {
+			b = &tokenBucket{tokens: float64(rate.Burst), last: now}
+			l.buckets[account+"\x00"+key] = b
+		}
+
+		// refill for the time since the bucket was last used
+		b.tokens += float64(now.Sub(b.last)) / float64(perToken)
+		if b.tokens > float64(rate.Burst) {
+			b.tokens = float64(rate.Burst)
+		}
+		b.last = now
+
+		if b.tokens < 1 {
+			if d := time.Duration((1 - b.tokens) * float64(perToken)); d > delay {
+				delay, limit = d, key
+			}
+		}
+
+		buckets = append(buckets, b)
+	}
+
+	if delay > 0 {
+		return delay, limit
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return 0, ""
+}
@@ -0,0 +1,186 @@
+package icloud
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for the limiter's clock. Sleeping advances it at once.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func newTestLimiter(t *testing.T, mode RateLimitMode, rates map[string]Rate) (*RateLimiter, *fakeClock) {
+	t.Helper()
+
+	l, err := NewRateLimiter(mode, rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l.now = clock.Now
+	l.sleep = clock.Sleep
+	return l, clock
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	// a token every 30s, up to 2 at once
+	l, clock := newTestLimiter(t, RateLimitFailFast, map[string]Rate{"hme.reserve": {Limit: 2, Per: time.Minute}})
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    time.Duration // the wait for a token, zero if one is taken
+	}{
+		{"full bucket", 0, 0},
+		{"burst", 0, 0},
+		{"empty", 0, 30 * time.Second},
+		{"half refilled", 15 * time.Second, 15 * time.Second},
+		{"refilled", 15 * time.Second, 0},
+		{"empty again", 0, 30 * time.Second},
+		{"idle", time.Hour, 0},
+		{"capped at the burst", 0, 0},
+		{"empty after the burst", 0, 30 * time.Second},
+	}
+
+	for _, step := range steps {
+		clock.Advance(step.advance)
+
+		delay, limit := l.take("user@icloud.com", []string{"hme.reserve"}, clock.Now())
+		if delay != step.want {
+			t.Fatalf("%s: waits %s, want %s", step.name, delay, step.want)
+		}
+		if step.want > 0 && limit != "hme.reserve" {
+			t.Errorf("%s: limited by %q, want hme.reserve", step.name, limit)
+		}
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	l, _ := newTestLimiter(t, RateLimitFailFast, map[string]Rate{
+		"hme.reserve": {Limit: 1, Per: time.Hour},
+		"hme":         {Limit: 3, Per: time.Hour},
+	})
+	ctx := context.Background()
+
+	if err := l.wait(ctx, "user@icloud.com", hmeReserve); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reserve bucket is empty, so the hme bucket is left alone
+	var rlErr *RateLimitError
+	if err := l.wait(ctx, "user@icloud.com", hmeReserve); !errors.As(err, &rlErr) || rlErr.Limit != "hme.reserve" {
+		t.Fatalf("second reserve = %v, want the hme.reserve limit", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.wait(ctx, "user@icloud.com", hmeList); err != nil {
+			t.Fatalf("list %d: %v", i, err)
+		}
+	}
+	if err := l.wait(ctx, "user@icloud.com", hmeList); !errors.As(err, &rlErr) || rlErr.Limit != "hme" {
+		t.Fatalf("fourth hme call = %v, want the hme limit", err)
+	}
+
+	// accounts have buckets of their own, whatever the case of the Apple ID
+	if err := l.wait(ctx, "other@icloud.com", hmeReserve); err != nil {
+		t.Errorf("another account was limited: %v", err)
+	}
+	if err := l.wait(ctx, " USER@icloud.com", hmeReserve); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("the same account in upper case = %v, want ErrRateLimited", err)
+	}
+
+	// calls without a limit and a nil limiter never wait
+	if err := l.wait(ctx, "user@icloud.com", mailSend); err != nil {
+		t.Errorf("an unlimited endpoint was limited: %v", err)
+	}
+	if err := (*RateLimiter)(nil).wait(ctx, "user@icloud.com", hmeReserve); err != nil {
+		t.Errorf("a nil limiter limited the call: %v", err)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	rates := map[string]Rate{"findmy": {Limit: 1, Per: 5 * time.Second}}
+
+	tests := []struct {
+		name    string
+		mode    RateLimitMode
+		timeout time.Duration // of the context, none if zero
+		cancel  bool
+
+		wantErr   error
+		wantSlept []time.Duration
+	}{
+		{name: "fail fast", mode: RateLimitFailFast, wantErr: ErrRateLimited},
+		{name: "wait", mode: RateLimitWait, wantSlept: []time.Duration{5 * time.Second}},
+		{name: "deadline after the wait", mode: RateLimitWait, timeout: time.Hour, wantSlept: []time.Duration{5 * time.Second}},
+		{name: "deadline before the wait", mode: RateLimitWait, timeout: time.Second, wantErr: ErrRateLimited},
+		{name: "cancelled", mode: RateLimitWait, cancel: true, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter(t, tt.mode, rates)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if tt.cancel {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				cancel()
+			}
+
+			if err := l.wait(context.Background(), "user@icloud.com", fmRefreshClient); err != nil {
+				t.Fatal(err)
+			}
+			start := clock.Now()
+
+			err := l.wait(ctx, "user@icloud.com", fmRefreshClient)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("wait = %v, want %v", err, tt.wantErr)
+			}
+
+			var rlErr *RateLimitError
+			if errors.As(err, &rlErr) && !rlErr.RetryAt.Equal(start.Add(5*time.Second)) {
+				t.Errorf("RetryAt = %s, want 5s after the call", rlErr.RetryAt)
+			}
+
+			if len(clock.slept) != len(tt.wantSlept) || (len(tt.wantSlept) > 0 && clock.slept[0] != tt.wantSlept[0]) {
+				t.Errorf("slept %v, want %v", clock.slept, tt.wantSlept)
+			}
+		})
+	}
+}
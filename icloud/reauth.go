@@ -0,0 +1,41 @@
+package icloud
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// reauthenticate re-establishes the iCloud session. It first repeats the accountLogin
+// calls with the current session and trust tokens, and falls back to a full login with the
+// stored credentials and two-factor handler if Apple no longer accepts them.
+//
+// gen is the auth generation the caller's failed request was sent with. Concurrent callers
+// that saw the same expired session wait on the lock and then share the result of the
+// first caller's attempt instead of each logging in again.
+func (c *Client) reauthenticate(ctx context.Context, gen uint64) error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if atomic.LoadUint64(&c.authGen) != gen {
+		return c.reauthErr
+	}
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	err := c.authenticateWeb(ctx)
+	if err != nil {
+		err = c.login(ctx, c.loginHandler)
+	}
+
+	// An attempt cut short by the caller's context says nothing about the session, so the
+	// next waiter tries again instead of inheriting the cancellation.
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+
+	c.reauthErr = err
+	atomic.AddUint64(&c.authGen, 1)
+
+	return err
+}
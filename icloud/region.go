@@ -0,0 +1,132 @@
+package icloud
+
+import (
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// * Region is the set of Apple hosts an account signs in through. Accounts registered in
+// mainland China live on icloud.com.cn and must use RegionChina; all others use
+// RegionGlobal. The client switches to the right region on its own when Apple redirects a
+// login, so setting it is only needed to skip that extra round trip.
+type Region struct {
+	// Name identifies the region in saved sessions, e.g. "global" or "china".
+	Name string
+
+	// AuthOrigin serves the idmsa sign in endpoints, e.g. https://idmsa.apple.com.
+	AuthOrigin string
+	// SetupOrigin serves accountLogin and validate, e.g. https://setup.icloud.com.
+	SetupOrigin string
+	// HomeOrigin is the iCloud web app sent as origin and referer, e.g. https://www.icloud.com.
+	HomeOrigin string
+
+	// Domain is the value Apple reports in domainToUse for this region, e.g. "iCloud.com".
+	Domain string
+	// Country is the account country code sent at login unless WithCountry is set.
+	Country string
+}
+
+var (
+	RegionGlobal = Region{
+		Name:        "global",
+		AuthOrigin:  "https://idmsa.apple.com",
+		SetupOrigin: "https://setup.icloud.com",
+		HomeOrigin:  "https://www.icloud.com",
+		Domain:      "iCloud.com",
+		Country:     "USA",
+	}
+
+	RegionChina = Region{
+		Name:        "china",
+		AuthOrigin:  "https://idmsa.apple.com.cn",
+		SetupOrigin: "https://setup.icloud.com.cn",
+		HomeOrigin:  "https://www.icloud.com.cn",
+		Domain:      "iCloud.com.cn",
+		Country:     "CHN",
+	}
+)
+
+// knownRegions are the regions a saved session or a domainToUse redirect can refer to.
+var knownRegions = []Region{RegionGlobal, RegionChina}
+
+// regionByName returns the known region with the given name.
+func regionByName(name string) (Region, bool) {
+	for _, r := range knownRegions {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// regionForDomain returns the known region Apple means by a domainToUse value.
+func regionForDomain(domain string) (Region, bool) {
+	for _, r := range knownRegions {
+		if strings.EqualFold(r.Domain, domain) {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+// cookieOrigin is the origin the iCloud service cookies are kept under. The cookie jar
+// groups cookies by registrable domain, so the bare domain covers every partition host.
+func (r Region) cookieOrigin() string {
+	return strings.Replace(r.HomeOrigin, "://www.", "://", 1)
+}
+
+// regionRedirect reports the region Apple asks the client to use instead of the current
+// one, taken from the domainToUse field of a federate or accountLogin response.
+func (c *Client) regionRedirect(body []byte) (Region, bool) {
+	domain := gjson.GetBytes(body, "domainToUse").String()
+	if domain == "" || strings.EqualFold(domain, c.currentRegion().Domain) {
+		return Region{}, false
+	}
+
+	return regionForDomain(domain)
+}
+
+// accountCountry returns the country code sent at login: the one set with WithCountry, or
+// the region's default.
+func (c *Client) accountCountry() string {
+	if c.country != "" {
+		return c.country
+	}
+	return c.currentRegion().Country
+}
+
+// origin is the region host an endpoint path is relative to.
+type origin uint8
+
+const (
+	originAuth origin = 1 + iota
+	originSetup
+)
+
+// endpointOrigins maps the sign in endpoints to the region host they live on. Their entries
+// in endpoints only hold the path.
+var endpointOrigins = map[endpoint]origin{
+	authStart:          originAuth,
+	authFederate:       originAuth,
+	authInit:           originAuth,
+	authComplete:       originAuth,
+	authOptions:        originAuth,
+	requestPhoneCode:   originAuth,
+	submitSecurityCode: originAuth,
+	trust:              originAuth,
+	authWeb:            originSetup,
+	authValidate:       originSetup,
+	authLogout:         originSetup,
+}
+
+// regionURL returns the URL of a sign in endpoint in the client's region.
+func (c *Client) regionURL(ep endpoint) string {
+	switch endpointOrigins[ep] {
+	case originAuth:
+		return c.currentRegion().AuthOrigin + endpoints[ep]
+	case originSetup:
+		return c.currentRegion().SetupOrigin + endpoints[ep]
+	}
+	return endpoints[ep]
+}
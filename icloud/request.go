@@ -0,0 +1,166 @@
+package icloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// sessionExpired reports whether a service status code means the iCloud session must be
+// re-established: 450 (re-authentication required) or 421 (misdirected/invalid session).
+func sessionExpired(statusCode int) bool {
+	return statusCode == 450 || statusCode == 421
+}
+
+// send builds and executes a service request to ep. build is called again for every attempt
+// so the request body and URL are fresh. Failures are retried as c.Retry allows, and if
+// AutoReauth is enabled and the service reports an expired session, the client
+// re-authenticates and replays the request once.
+func (c *Client) send(ctx context.Context, ep endpoint, build func() (*http.Request, error)) (*http.Response, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+
+	gen := atomic.LoadUint64(&c.authGen)
+
+	resp, err := c.do(ctx, ep, build)
+	if err != nil || !c.AutoReauth || !sessionExpired(resp.StatusCode) {
+		return resp, err
+	}
+
+	resp.Body.Close()
+
+	if c.Logger != nil {
+		c.Logger.LogAttrs(ctx, slog.LevelInfo, "icloud session expired, re-authenticating",
+			slog.String("endpoint", ep.String()), slog.Int("status", resp.StatusCode))
+	}
+
+	if err := c.reauthenticate(ctx, gen); err != nil {
+		return nil, fmt.Errorf("%s: re-authenticate: %w", ep, err)
+	}
+
+	return c.do(ctx, ep, build)
+}
+
+// sendJSON sends body to ep as JSON, see send. body is marshalled once, without HTML
+// escaping; nil sends no body and a json.RawMessage is sent as written. url returns the
+// URL for each attempt, as it may carry tokens that change between attempts; nil uses the
+// endpoint's webservice URL. The contacts service expects its JSON as text/plain, like the
+// iCloud web app sends it.
+func (c *Client) sendJSON(ctx context.Context, ep endpoint, method string, url func() (string, error), body interface{}) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(body); err != nil {
+			return nil, fmt.Errorf("%s: marshal request: %w", ep, err)
+		}
+		data = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	}
+
+	if url == nil {
+		url = func() (string, error) { return c.endpointURL(ep) }
+	}
+
+	return c.send(ctx, ep, func() (*http.Request, error) {
+		u, err := url()
+		if err != nil {
+			return nil, err
+		}
+
+		var reqBody io.Reader
+		if data != nil {
+			reqBody = bytes.NewReader(data)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+		if err != nil {
+			return nil, err
+		}
+
+		origin := c.currentRegion().HomeOrigin
+		if ep.service() == "contacts" {
+			req.Header.Set(HdrContentType, "text/plain;charset=UTF-8")
+			req.Header.Set("referer", origin+"/")
+		} else {
+			req.Header.Set(HdrContentType, "application/json")
+		}
+		req.Header.Set("origin", origin)
+		req.Header.Set("accept", "*/*")
+
+		return req, nil
+	})
+}
+
+// do sends the request built by build, retrying failures that c.Retry allows for ep. The
+// last response or error is returned once the policy gives up. The call takes one token
+// from c.RateLimiter first; its retries do not take more.
+func (c *Client) do(ctx context.Context, ep endpoint, build func() (*http.Request, error)) (*http.Response, error) {
+	if err := c.RateLimiter.wait(ctx, c.Username, ep); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := c.buildRequest(build)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.roundTrip(ep, req)
+
+		delay, retry := c.Retry.delay(ep, attempt, resp, err)
+		if !retry || ctx.Err() != nil {
+			return resp, err
+		}
+
+		if c.Logger != nil {
+			c.Logger.LogAttrs(ctx, slog.LevelInfo, "icloud request retry",
+				slog.String("endpoint", ep.String()), slog.Int("attempt", attempt), slog.Duration("delay", delay))
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// roundTrip sends req to ep through c.HttpClient, and logs and traces it, see
+// Client.Logger and WithTracerProvider. Every request the client makes goes through here.
+func (c *Client) roundTrip(ep endpoint, req *http.Request) (*http.Response, error) {
+	span := c.startRequest(ep, req)
+
+	start := time.Now()
+	resp, err := c.HttpClient.Do(req)
+	latency := time.Since(start)
+
+	c.logRequest(req.Context(), ep, req, resp, err, latency)
+	c.recordRequest(req.Context(), span, ep, resp, err, latency)
+
+	return resp, err
+}
+
+// buildRequest calls build and fills in the headers every service request carries.
+func (c *Client) buildRequest(build func() (*http.Request, error)) (*http.Request, error) {
+	req, err := build()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	return req, nil
+}
@@ -0,0 +1,133 @@
+package icloud
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// DefaultRetryPolicy is the retry policy of clients created with NewClientWithOptions.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// RetryPolicy controls how service calls are retried after a network error or a 429, 500,
+// 502, 503 or 504 response. The delay doubles with every attempt, starting at BaseDelay,
+// and a random part of up to half of it is taken off so clients do not retry in lockstep.
+// A Retry-After header sent by Apple replaces the computed delay.
+//
+// Only calls that do not change anything are retried by default. Calls such as ReserveHME,
+// SendDraft, CreateContact or PlaySound could be carried out twice if the first attempt
+// reached Apple, so they are not retried at all unless RetryMutations is set.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Zero or one
+	// disables retries.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between attempts. A Retry-After longer than MaxDelay is not
+	// waited for; the failure is returned instead.
+	MaxDelay time.Duration
+
+	// RetryMutations also retries calls that change state. Only set it if a duplicate HME,
+	// email or contact is acceptable.
+	RetryMutations bool
+}
+
+// mutations are the endpoints that are not safe to send twice.
+var mutations = map[endpoint]bool{
+	hmeReserve:     true,
+	mailDraft:      true,
+	mailSend:       true,
+	fmPlaySound:    true,
+	contactsCreate: true,
+	contactsUpdate: true,
+	contactsDelete: true,
+}
+
+// delay returns how long to wait before attempt number attempt+1 of ep after resp or err,
+// and false if the call should not be retried.
+func (p RetryPolicy) delay(ep endpoint, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	if mutations[ep] && !p.RetryMutations {
+		return 0, false
+	}
+
+	var retryAfter time.Duration
+	if err == nil {
+		if !retryableStatus(resp.StatusCode) {
+			return 0, false
+		}
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+
+	if retryAfter > 0 {
+		if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+			return 0, false
+		}
+		return retryAfter, true
+	}
+
+	return p.backoff(attempt), true
+}
+
+// backoff returns the delay after the given attempt: BaseDelay doubled for every earlier
+// attempt, capped at MaxDelay, with up to half of it taken off at random.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as an HTTP date.
+// It returns zero if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// sleepContext waits for d, returning early with ctx.Err() if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
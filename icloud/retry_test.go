@@ -0,0 +1,197 @@
+package icloud
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		attempt int
+		full    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{30, time.Second},
+	}
+
+	for _, tt := range tests {
+		// up to half of the delay is taken off at random
+		for i := 0; i < 200; i++ {
+			d := p.backoff(tt.attempt)
+			if d < tt.full/2 || d > tt.full {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, d, tt.full/2, tt.full)
+			}
+		}
+	}
+
+	if d := (RetryPolicy{}).backoff(1); d != 0 {
+		t.Errorf("backoff without a BaseDelay = %s, want 0", d)
+	}
+	if d := (RetryPolicy{BaseDelay: time.Second}).backoff(4); d < 4*time.Second || d > 8*time.Second {
+		t.Errorf("backoff(4) without a MaxDelay = %s, want between 4s and 8s", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"Fri, 01 Mar 2024 12:00:30 GMT", 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Minute}
+	withMutations := policy
+	withMutations.RetryMutations = true
+
+	errNetwork := errors.New("connection reset")
+
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		ep         endpoint
+		attempt    int
+		status     int
+		retryAfter string
+		err        error
+
+		wantRetry bool
+		wantDelay time.Duration // checked if not zero
+	}{
+		{name: "503", policy: policy, ep: hmeList, attempt: 1, status: 503, wantRetry: true},
+		{name: "429", policy: policy, ep: hmeList, attempt: 1, status: 429, wantRetry: true},
+		{name: "network error", policy: policy, ep: hmeList, attempt: 1, err: errNetwork, wantRetry: true},
+		{name: "404", policy: policy, ep: hmeList, attempt: 1, status: 404},
+		{name: "200", policy: policy, ep: hmeList, attempt: 1, status: 200},
+		{name: "last attempt", policy: policy, ep: hmeList, attempt: 3, status: 503},
+		{name: "disabled", policy: RetryPolicy{}, ep: hmeList, attempt: 1, status: 503},
+
+		{name: "Retry-After", policy: policy, ep: hmeList, attempt: 1, status: 429, retryAfter: "7", wantRetry: true, wantDelay: 7 * time.Second},
+		{name: "Retry-After over MaxDelay", policy: policy, ep: hmeList, attempt: 1, status: 429, retryAfter: "120"},
+
+		{name: "mutation 429", policy: policy, ep: hmeReserve, attempt: 1, status: 429},
+		{name: "mutation 503", policy: policy, ep: mailSend, attempt: 1, status: 503},
+		{name: "mutation network error", policy: policy, ep: contactsCreate, attempt: 1, err: errNetwork},
+		{name: "RetryMutations 429", policy: withMutations, ep: hmeReserve, attempt: 1, status: 429, wantRetry: true},
+		{name: "RetryMutations 503", policy: withMutations, ep: mailSend, attempt: 1, status: 503, wantRetry: true},
+		{name: "RetryMutations network error", policy: withMutations, ep: contactsCreate, attempt: 1, err: errNetwork, wantRetry: true},
+		{name: "RetryMutations 400", policy: withMutations, ep: hmeReserve, attempt: 1, status: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status, Header: http.Header{}}
+				if tt.retryAfter != "" {
+					resp.Header.Set("Retry-After", tt.retryAfter)
+				}
+			}
+
+			delay, retry := tt.policy.delay(tt.ep, tt.attempt, resp, tt.err)
+			if retry != tt.wantRetry {
+				t.Fatalf("retry = %v, want %v", retry, tt.wantRetry)
+			}
+			if tt.wantDelay != 0 && delay != tt.wantDelay {
+				t.Errorf("delay = %s, want %s", delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+// scriptedTransport answers requests with the given status codes in turn, then with 200.
+type scriptedTransport struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+}
+
+func (s *scriptedTransport) Do(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := 200
+	if s.requests < len(s.statuses) {
+		status = s.statuses[s.requests]
+	}
+	s.requests++
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+func (s *scriptedTransport) GetCookies(u *url.URL) []*http.Cookie { return nil }
+
+func (s *scriptedTransport) SetCookies(u *url.URL, cookies []*http.Cookie) {}
+
+func TestRetryTakesOneToken(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitFailFast, map[string]Rate{"hme": {Limit: 1, Per: time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &scriptedTransport{statuses: []int{503, 503}}
+	c, err := NewClientWithOptions(
+		WithCredentials("user@icloud.com", "password"),
+		WithTransport(transport),
+		WithRateLimiter(limiter),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, "https://p00-maildomainws.icloud.com/v2/hme/list", nil)
+	}
+
+	resp, err := c.do(context.Background(), hmeList, build)
+	if err != nil {
+		t.Fatalf("the retried call was held back: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || transport.requests != 3 {
+		t.Fatalf("got %d after %d requests, want 200 after 3", resp.StatusCode, transport.requests)
+	}
+
+	// the bucket had one token, which the call above used up
+	if _, err := c.do(context.Background(), hmeList, build); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second call = %v, want ErrRateLimited", err)
+	}
+}
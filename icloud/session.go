@@ -0,0 +1,216 @@
+package icloud
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// sessionStateVersion is bumped whenever SessionState changes in a way that older
+// exports can no longer be loaded.
+const sessionStateVersion = 2
+
+// sessionStateVersionURLs is the last version that stored the account, findme and contacts
+// URLs instead of the full webservices map. It is still accepted by LoadSession.
+const sessionStateVersionURLs = 1
+
+// sessionCookieURLs returns the origins whose cookies make up an authenticated session.
+// The cookie jar groups cookies by registrable domain, so one URL per domain is enough.
+// The caller must hold c.mu or c.authMu.
+func (c *Client) sessionCookieURLs() []string {
+	if cookieOrigin := c.region.cookieOrigin(); cookieOrigin != c.region.AuthOrigin {
+		return []string{c.region.AuthOrigin, cookieOrigin}
+	}
+
+	// a custom region, e.g. a test server, may serve sign in and iCloud from one origin
+	return []string{c.region.AuthOrigin}
+}
+
+// SessionCookie is a serializable copy of a cookie held in the client's cookie jar.
+type SessionCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Expires  time.Time `json:"expires"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"httpOnly,omitempty"`
+}
+
+// SessionState holds everything needed to resume an authenticated iCloud session
+// without running Login again. It is safe to marshal as JSON, but it contains live
+// credentials (session cookies and tokens) and must be stored accordingly.
+// The account password is never included.
+type SessionState struct {
+	Version  int       `json:"version"`
+	Username string    `json:"username"`
+	SavedAt  time.Time `json:"savedAt"`
+	// Region is the name of the Region the session was created in. Empty means RegionGlobal.
+	Region string `json:"region,omitempty"`
+
+	AuthToken      string `json:"authToken"`
+	TrustToken     string `json:"trustToken"`
+	FrameID        string `json:"frameId"`
+	ClientID       string `json:"clientId"`
+	AuthAttributes string `json:"authAttributes,omitempty"`
+	SessionID      string `json:"sessionId,omitempty"`
+	Scnt           string `json:"scnt,omitempty"`
+
+	Dsid        string                `json:"dsid"`
+	Webservices map[string]Webservice `json:"webservices"`
+
+	// Deprecated: version 1 sessions stored these three URLs instead of Webservices.
+	// They are only read by LoadSession and are never exported.
+	AccountURL  string `json:"accountUrl,omitempty"`
+	FindMeURL   string `json:"findMeUrl,omitempty"`
+	ContactsURL string `json:"contactsUrl,omitempty"`
+
+	// Cookies are keyed by the origin they were read from, e.g. https://icloud.com.
+	Cookies map[string][]SessionCookie `json:"cookies"`
+}
+
+// ExportSession returns a snapshot of the client's authenticated session, including
+// the cookies for idmsa and the iCloud domain of the client's region. Load it into a new Client with
+// LoadSession to resume the session without logging in again.
+func (c *Client) ExportSession() (*SessionState, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if c.dsid == "" || c.authToken == "" {
+		return nil, ErrNotAuthenticated
+	}
+
+	state := &SessionState{
+		Version:        sessionStateVersion,
+		Username:       c.Username,
+		SavedAt:        time.Now().UTC(),
+		Region:         c.region.Name,
+		AuthToken:      c.authToken,
+		TrustToken:     c.trustToken,
+		FrameID:        c.frameId,
+		ClientID:       c.clientId,
+		AuthAttributes: c.authAttr,
+		SessionID:      c.sessionID,
+		Scnt:           c.scnt,
+		Dsid:           c.dsid,
+		Webservices:    c.copyWebservices(),
+		Cookies:        make(map[string][]SessionCookie),
+	}
+
+	for _, rawURL := range c.sessionCookieURLs() {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cookie := range c.HttpClient.GetCookies(u) {
+			state.Cookies[rawURL] = append(state.Cookies[rawURL], SessionCookie{
+				Name:     cookie.Name,
+				Value:    cookie.Value,
+				Domain:   cookie.Domain,
+				Path:     cookie.Path,
+				Expires:  cookie.Expires,
+				Secure:   cookie.Secure,
+				HttpOnly: cookie.HttpOnly,
+			})
+		}
+	}
+
+	return state, nil
+}
+
+// LoadSession restores a session previously returned by ExportSession. The cookies are
+// added to the client's cookie jar and the tokens and service URLs replace the client's
+// current state. Call ValidateSession afterwards to check that the session is still live.
+func (c *Client) LoadSession(state *SessionState) error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	return c.loadSession(state)
+}
+
+// loadSession is LoadSession for callers that already hold authMu.
+func (c *Client) loadSession(state *SessionState) error {
+	if state == nil {
+		return ErrInvalidSessionState
+	}
+	if state.Version != sessionStateVersion && state.Version != sessionStateVersionURLs {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSessionState, state.Version)
+	}
+	if state.Username != "" && c.Username != "" && state.Username != c.Username {
+		return fmt.Errorf("%w: session belongs to a different account", ErrInvalidSessionState)
+	}
+
+	for rawURL, cookies := range state.Cookies {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSessionState, err)
+		}
+
+		jarCookies := make([]*http.Cookie, 0, len(cookies))
+		for _, cookie := range cookies {
+			jarCookies = append(jarCookies, &http.Cookie{
+				Name:     cookie.Name,
+				Value:    cookie.Value,
+				Domain:   cookie.Domain,
+				Path:     cookie.Path,
+				Expires:  cookie.Expires,
+				Secure:   cookie.Secure,
+				HttpOnly: cookie.HttpOnly,
+			})
+		}
+
+		c.HttpClient.SetCookies(u, jarCookies)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Username == "" {
+		c.Username = state.Username
+	}
+
+	// A session from a known region moves the client there. Custom regions can not be
+	// recovered from their name, so the client's own region is kept for those.
+	if region, ok := regionByName(state.Region); ok {
+		c.region = region
+	}
+
+	c.authToken = state.AuthToken
+	c.trustToken = state.TrustToken
+	c.frameId = state.FrameID
+	c.clientId = state.ClientID
+	c.authAttr = state.AuthAttributes
+	c.sessionID = state.SessionID
+	c.scnt = state.Scnt
+	c.dsid = state.Dsid
+	c.webservices = make(map[string]Webservice, len(state.Webservices))
+	for name, ws := range state.Webservices {
+		c.webservices[name] = ws
+	}
+
+	if state.Version == sessionStateVersionURLs {
+		for name, rawURL := range map[string]string{
+			wsAccount:  state.AccountURL,
+			wsFindMe:   state.FindMeURL,
+			wsContacts: state.ContactsURL,
+		} {
+			if rawURL != "" {
+				c.webservices[name] = Webservice{URL: rawURL}
+			}
+		}
+	}
+
+	// Per-service state is tied to the old process; it is re-established on first use.
+	c.accountInfo = nil
+	c.fmServerCtx = nil
+	c.syncToken = ""
+	c.prefToken = ""
+
+	return nil
+}
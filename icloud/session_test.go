@@ -0,0 +1,192 @@
+package icloud_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+)
+
+func newSessionServer(t *testing.T) *icloudtest.Server {
+	t.Helper()
+
+	srv := icloudtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	err := srv.AddAccount(icloudtest.Account{
+		AppleID:    "user@icloud.com",
+		Password:   "password",
+		ICloudPlus: true,
+		Devices:    []icloud.FMDevice{{ID: "device-1", Name: "iPhone"}},
+		Contacts:   []icloud.Contact{{FirstName: "John", LastName: "Appleseed"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv
+}
+
+// exportedSession logs in to srv and returns the exported session as it would be read
+// back from storage.
+func exportedSession(t *testing.T, srv *icloudtest.Server) *icloud.SessionState {
+	t.Helper()
+
+	client := loggedInClient(t, srv, "user@icloud.com")
+	state, err := client.ExportSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored icloud.SessionState
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	return &stored
+}
+
+// resumedClient returns a client that has not logged in, with state loaded into it.
+func resumedClient(t *testing.T, srv *icloudtest.Server, state *icloud.SessionState) *icloud.Client {
+	t.Helper()
+
+	client, err := srv.NewClient("user@icloud.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.LoadSession(state); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	srv := newSessionServer(t)
+	state := exportedSession(t, srv)
+
+	if state.Version != 2 || state.Dsid == "" || state.AuthToken == "" || len(state.Webservices) == 0 || len(state.Cookies) == 0 {
+		t.Fatalf("exported session is incomplete: %+v", state)
+	}
+
+	signins := srv.Calls("auth.init")
+	client := resumedClient(t, srv, state)
+
+	if ok, err := client.ValidateSession(); err != nil || !ok {
+		t.Fatalf("ValidateSession = %v, %v, want a live session", ok, err)
+	}
+	if err := callServices(client); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Calls("auth.init") != signins {
+		t.Error("the resumed client signed in again")
+	}
+
+	again, err := client.ExportSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Webservices, state.Webservices) || again.Dsid != state.Dsid || again.AuthToken != state.AuthToken {
+		t.Errorf("exported again as %+v, want %+v", again, state)
+	}
+}
+
+func TestSessionLoadVersion1(t *testing.T) {
+	srv := newSessionServer(t)
+	state := exportedSession(t, srv)
+
+	// version 1 kept three service URLs instead of the webservices map
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v1 map[string]interface{}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		t.Fatal(err)
+	}
+	delete(v1, "webservices")
+	v1["version"] = 1
+	v1["accountUrl"] = state.Webservices["account"].URL
+	v1["findMeUrl"] = state.Webservices["findme"].URL
+	v1["contactsUrl"] = state.Webservices["contacts"].URL
+
+	data, err = json.Marshal(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var old icloud.SessionState
+	if err := json.Unmarshal(data, &old); err != nil {
+		t.Fatal(err)
+	}
+
+	client := resumedClient(t, srv, &old)
+
+	if _, err := client.GetDevices(); err != nil {
+		t.Errorf("findmy after loading a version 1 session: %v", err)
+	}
+	if _, err := client.GetContacts(); err != nil {
+		t.Errorf("contacts after loading a version 1 session: %v", err)
+	}
+	if _, err := client.AccountInfo(); err != nil {
+		t.Errorf("account after loading a version 1 session: %v", err)
+	}
+
+	migrated, err := client.ExportSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Version != 2 {
+		t.Errorf("exported as version %d, want 2", migrated.Version)
+	}
+	for _, name := range []string{"account", "findme", "contacts"} {
+		if got, want := migrated.Webservices[name].URL, state.Webservices[name].URL; got != want {
+			t.Errorf("webservice %s = %q, want %q", name, got, want)
+		}
+	}
+	if migrated.AccountURL != "" || migrated.FindMeURL != "" || migrated.ContactsURL != "" {
+		t.Error("the version 1 URLs were exported again")
+	}
+}
+
+func TestSessionLoadRejects(t *testing.T) {
+	srv := newSessionServer(t)
+	state := exportedSession(t, srv)
+
+	other := *state
+	other.Username = "someone@icloud.com"
+
+	tests := []struct {
+		name  string
+		state *icloud.SessionState
+	}{
+		{"nil", nil},
+		{"version 0", withVersion(state, 0)},
+		{"version 3", withVersion(state, 3)},
+		{"other account", &other},
+	}
+
+	for _, tt := range tests {
+		client, err := srv.NewClient("user@icloud.com", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.LoadSession(tt.state); !errors.Is(err, icloud.ErrInvalidSessionState) {
+			t.Errorf("%s: LoadSession = %v, want ErrInvalidSessionState", tt.name, err)
+		}
+		if _, err := client.ExportSession(); !errors.Is(err, icloud.ErrNotAuthenticated) {
+			t.Errorf("%s: a rejected session was loaded, ExportSession = %v", tt.name, err)
+		}
+	}
+}
+
+func withVersion(state *icloud.SessionState, version int) *icloud.SessionState {
+	s := *state
+	s.Version = version
+	return &s
+}
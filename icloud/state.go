@@ -0,0 +1,106 @@
+package icloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// The accessors below read and write the session state under c.mu, see the concurrency
+// notes on Client. Code running under authMu may read the sign in fields directly, since
+// every writer of those fields holds authMu as well.
+
+// currentRegion returns the region the client signs in through.
+func (c *Client) currentRegion() Region {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.region
+}
+
+// accountIDs returns the frame id and dsid sent as clientId and dsid on service requests.
+func (c *Client) accountIDs() (frameID, dsid string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.frameId, c.dsid
+}
+
+// cachedAccountInfo returns the dsInfo from the last accountLogin, running accountLogin
+// again if the session was resumed without it.
+func (c *Client) cachedAccountInfo(ctx context.Context) (AccountInfo, error) {
+	c.mu.RLock()
+	info := c.accountInfo
+	c.mu.RUnlock()
+	if info != nil {
+		return *info, nil
+	}
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.closed {
+		return AccountInfo{}, ErrClientClosed
+	}
+	if c.accountInfo == nil {
+		if err := c.authenticateWeb(ctx); err != nil {
+			return AccountInfo{}, err
+		}
+		if c.accountInfo == nil {
+			return AccountInfo{}, errors.New("accountLogin response did not include account info")
+		}
+	}
+
+	return *c.accountInfo, nil
+}
+
+// isClosed reports whether Logout has been called.
+func (c *Client) isClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closed
+}
+
+// updateScnt stores the scnt Apple returns on every idmsa response; it must be forwarded on
+// the next request.
+func (c *Client) updateScnt(resp *http.Response) {
+	if scnt := resp.Header.Get(HdrScnt); scnt != "" {
+		c.mu.Lock()
+		c.scnt = scnt
+		c.mu.Unlock()
+	}
+}
+
+// fmServerContext returns the Find My server context from the last refreshClient call.
+func (c *Client) fmServerContext() json.RawMessage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.fmServerCtx
+}
+
+func (c *Client) setFMServerContext(serverCtx json.RawMessage) {
+	c.mu.Lock()
+	c.fmServerCtx = serverCtx
+	c.mu.Unlock()
+}
+
+// contactsTokens returns the contacts sync and preference tokens.
+func (c *Client) contactsTokens() (syncToken, prefToken string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.syncToken, c.prefToken
+}
+
+// setContactsTokens stores the tokens from a contacts response. Empty tokens leave the
+// current ones in place.
+func (c *Client) setContactsTokens(syncToken, prefToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if syncToken != "" {
+		c.syncToken = syncToken
+	}
+	if prefToken != "" {
+		c.prefToken = prefToken
+	}
+}
@@ -1,7 +1,10 @@
 package icloud
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 
 	http "github.com/bogdanfinn/fhttp"
 )
@@ -11,6 +14,12 @@ const (
 	OAuthClientID   = "d39ba9916b7251055b22c7f910e2ea796ee65e98b2ddecea8f5dde8d9d1a815d"
 )
 
+// Delivery modes for two-factor codes sent to a trusted phone number.
+const (
+	TwoFactorModeSMS   = "sms"
+	TwoFactorModeVoice = "voice"
+)
+
 const (
 	HdrContentType       = "Content-Type"
 	HdrXRequestedWith    = "X-Requested-With"
@@ -28,7 +37,25 @@ var (
 	ErrSeverErrorOrInvalidCreds  = errors.New("apple server error or invalid credentials")
 	ErrFindMySessionExpired      = errors.New("find my session expired: please call Login() again")
 	ErrSessionExpired            = errors.New("icloud session expired: please call Login() again")
-	ErrContactEtagMismatch = errors.New("contact etag mismatch: contact was modified")
+	ErrContactEtagMismatch       = errors.New("contact etag mismatch: contact was modified")
+	ErrInvalidSessionState       = errors.New("invalid session state")
+	ErrSessionNotFound           = errors.New("no stored session for account")
+	ErrNoTwoFactorMethod         = errors.New("no trusted device or phone number available for two-factor authentication")
+	ErrTwoFactorRequired         = errors.New("two-factor authentication required but no handler was given")
+	ErrIncorrectOTP              = errors.New("incorrect verification code")
+	ErrResendCode                = errors.New("resend two-factor code")
+	ErrTooManyResends            = errors.New("too many two-factor code resends")
+	ErrServerProofMismatch       = errors.New("server SRP proof did not match: the server could not prove it knows the password verifier")
+	ErrServerProofMissing        = errors.New("sign in response has no server SRP proof")
+	ErrClientClosed              = errors.New("client was logged out and can no longer be used")
+	ErrNotAuthenticated          = errors.New("no authenticated session: call Login() first")
+	ErrRegionChanged             = errors.New("account belongs to another iCloud region")
+	ErrServiceUnavailable        = errors.New("icloud service not available: call Login() first")
+	ErrAccountLocked             = errors.New("apple id is locked: unlock it at https://iforgot.apple.com")
+	ErrAccountNotFound           = errors.New("account not in pool")
+	ErrNoHealthyAccount          = errors.New("no healthy account in pool")
+	ErrPoolClosed                = errors.New("pool is closed")
+	ErrRateLimited               = errors.New("client-side rate limit reached")
 )
 
 type endpoint uint8
@@ -40,10 +67,16 @@ const (
 	authInit
 	authComplete
 	authOptions
+	requestPhoneCode
 	submitSecurityCode
 	trust
 	authWeb
+	authWebPartition
 	authValidate
+	authLogout
+
+	accountStorage
+	accountFamily
 
 	hmeList
 	hmeGen
@@ -58,36 +91,116 @@ const (
 	mailDelete
 	mailDraft
 	mailSend
+
+	// Find My and Contacts URLs are built from the account's webservices, see fmipURL
+	// and buildContactsURL.
+	fmRefreshClient
+	fmPlaySound
+
+	contactsStartup
+	contactsList
+	contactsCreate
+	contactsUpdate
+	contactsDelete
 )
 
+// endpointNames are the names endpoints are reported under in errors, as service.call.
+var endpointNames = map[endpoint]string{
+	iTunesConnect:      "auth.itunesConnect",
+	authStart:          "auth.start",
+	authFederate:       "auth.federate",
+	authInit:           "auth.init",
+	authComplete:       "auth.complete",
+	authOptions:        "auth.options",
+	requestPhoneCode:   "auth.requestPhoneCode",
+	submitSecurityCode: "auth.submitSecurityCode",
+	trust:              "auth.trust",
+	authWeb:            "auth.accountLogin",
+	authWebPartition:   "auth.partitionLogin",
+	authValidate:       "auth.validate",
+	authLogout:         "auth.logout",
+
+	accountStorage: "account.storage",
+	accountFamily:  "account.family",
+
+	hmeList:       "hme.list",
+	hmeGen:        "hme.generate",
+	hmeReserve:    "hme.reserve",
+	hmeDeactivate: "hme.deactivate",
+	hmeReactivate: "hme.reactivate",
+	hmeDelete:     "hme.delete",
+
+	mailInbox:       "mail.inbox",
+	mailMetadataGet: "mail.metadata",
+	mailGet:         "mail.get",
+	mailDelete:      "mail.delete",
+	mailDraft:       "mail.draft",
+	mailSend:        "mail.send",
+
+	fmRefreshClient: "findmy.refreshClient",
+	fmPlaySound:     "findmy.playSound",
+
+	contactsStartup: "contacts.startup",
+	contactsList:    "contacts.list",
+	contactsCreate:  "contacts.create",
+	contactsUpdate:  "contacts.update",
+	contactsDelete:  "contacts.delete",
+}
+
+func (e endpoint) String() string {
+	if name, ok := endpointNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("endpoint(%d)", uint8(e))
+}
+
+// service returns the service part of the endpoint name.
+func (e endpoint) service() string {
+	name := e.String()
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// endpoints holds the URL of each endpoint. Sign in paths are relative to the client's
+// region, see endpointOrigins.
 var endpoints = map[endpoint]string{
-	authStart:          "https://idmsa.apple.com/appleauth/auth/authorize/signin?frame_id=auth-%s&language=en_US&skVersion=7&iframeId=auth-%s&client_id=%s&redirect_uri=https://www.icloud.com&response_type=code&response_mode=web_message&state=auth-%s&authVersion=latest",
-	authFederate:       "https://idmsa.apple.com/appleauth/auth/federate?isRememberMeEnabled=true",
-	authInit:           "https://idmsa.apple.com/appleauth/auth/signin/init",
-	authComplete:       "https://idmsa.apple.com/appleauth/auth/signin/complete?isRememberMeEnabled=true",
-	authOptions:        "https://idmsa.apple.com/appleauth/auth",
-	submitSecurityCode: "https://idmsa.apple.com/appleauth/auth/verify/%s/securitycode", // code type, typically trusteddevice
-	trust:              "https://idmsa.apple.com/appleauth/auth/2sv/trust",
-	authWeb:            "https://setup.icloud.com/setup/ws/1/accountLogin",
-	authValidate:       "https://setup.icloud.com/setup/ws/1/validate?clientBuildNumber=2602Build17&clientMasteringNumber=2602Build17&clientId=%s&dsid=%s",
-
-	hmeList:       "https://p52-maildomainws.icloud.com/v2/hme/list?clientBuildNumber=2426Hotfix51&clientMasteringNumber=2426Hotfix51",
-	hmeGen:        "https://p52-maildomainws.icloud.com/v1/hme/generate?clientBuildNumber=2415Project29&clientMasteringNumber=2415B20",
-	hmeReserve:    "https://p52-maildomainws.icloud.com/v1/hme/reserve?clientBuildNumber=2415Project29&clientMasteringNumber=2415B20",
-	hmeDeactivate: "https://p52-maildomainws.icloud.com/v1/hme/deactivate?clientBuildNumber=2426Hotfix10&clientMasteringNumber=2426Hotfix10",
-	hmeReactivate: "https://p52-maildomainws.icloud.com/v1/hme/reactivate?clientBuildNumber=2426Hotfix10&clientMasteringNumber=2426Hotfix10",
-	hmeDelete:     "https://p52-maildomainws.icloud.com/v1/hme/delete?clientBuildNumber=2426Hotfix10&clientMasteringNumber=2426Hotfix10",
-
-	mailInbox:       "https://p52-mccgateway.icloud.com/mailws2/v1/thread/search?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
-	mailMetadataGet: "https://p52-mccgateway.icloud.com/mailws2/v1/thread/get?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
-	mailGet:         "https://p52-mccgateway.icloud.com/mailws2/v1/message/get?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
-	mailDelete:      "https://p52-mailws.icloud.com/wm/message?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
-	mailDraft:       "https://p52-mailws.icloud.com/wm/message?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
-	mailSend:        "https://p52-mccgateway.icloud.com/mailws2/v1/draft/send?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
+	authStart:          "/appleauth/auth/authorize/signin?frame_id=auth-%s&language=%s&skVersion=7&iframeId=auth-%s&client_id=%s&redirect_uri=%s&response_type=code&response_mode=web_message&state=auth-%s&authVersion=latest",
+	authFederate:       "/appleauth/auth/federate?isRememberMeEnabled=true",
+	authInit:           "/appleauth/auth/signin/init",
+	authComplete:       "/appleauth/auth/signin/complete?isRememberMeEnabled=true",
+	authOptions:        "/appleauth/auth",
+	requestPhoneCode:   "/appleauth/auth/verify/phone",
+	submitSecurityCode: "/appleauth/auth/verify/%s/securitycode", // code type, trusteddevice or phone
+	trust:              "/appleauth/auth/2sv/trust",
+	authWeb:            "/setup/ws/1/accountLogin",
+	authValidate:       "/setup/ws/1/validate?clientBuildNumber=2602Build17&clientMasteringNumber=2602Build17&clientId=%s&dsid=%s",
+	authLogout:         "/setup/ws/1/logout?clientBuildNumber=2602Build17&clientMasteringNumber=2602Build17&clientId=%s&dsid=%s",
+
+	// Account, HME and Mail paths are relative to the account's webservices, see endpointServices.
+	accountStorage: "/setup/ws/1/storageUsageInfo?clientBuildNumber=2602Build17&clientMasteringNumber=2602Build17&clientId=%s&dsid=%s",
+	accountFamily:  "/setup/web/family/getFamilyDetailsForClient?clientBuildNumber=2602Build17&clientMasteringNumber=2602Build17&clientId=%s&dsid=%s",
+
+	hmeList:       "/v2/hme/list?clientBuildNumber=2426Hotfix51&clientMasteringNumber=2426Hotfix51",
+	hmeGen:        "/v1/hme/generate?clientBuildNumber=2415Project29&clientMasteringNumber=2415B20",
+	hmeReserve:    "/v1/hme/reserve?clientBuildNumber=2415Project29&clientMasteringNumber=2415B20",
+	hmeDeactivate: "/v1/hme/deactivate?clientBuildNumber=2426Hotfix10&clientMasteringNumber=2426Hotfix10",
+	hmeReactivate: "/v1/hme/reactivate?clientBuildNumber=2426Hotfix10&clientMasteringNumber=2426Hotfix10",
+	hmeDelete:     "/v1/hme/delete?clientBuildNumber=2426Hotfix10&clientMasteringNumber=2426Hotfix10",
+
+	mailInbox:       "/mailws2/v1/thread/search?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
+	mailMetadataGet: "/mailws2/v1/thread/get?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
+	mailGet:         "/mailws2/v1/message/get?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
+	mailDelete:      "/wm/message?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
+	mailDraft:       "/wm/message?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
+	mailSend:        "/mailws2/v1/draft/send?clientBuildNumber=2426Hotfix40&clientMasteringNumber=2426Hotfix40",
 }
 
 // updateRequestHeaders updates required request headers.
 func (c *Client) updateRequestHeaders(header http.Header) http.Header {
+	region := c.currentRegion()
+
 	if c.scnt != "" {
 		header.Set(HdrScnt, c.scnt)
 	}
@@ -98,19 +211,19 @@ func (c *Client) updateRequestHeaders(header http.Header) http.Header {
 	header.Set(HdrXRequestedWith, "XMLHttpRequest")
 	header.Set(HdrContentType, "application/json")
 	header.Set(HdrAccept, "application/json")
-	header.Set("referer", "https://idmsa.apple.com/")
-	header.Set("origin", "https://idmsa.apple.com")
+	header.Set("referer", region.AuthOrigin+"/")
+	header.Set("origin", region.AuthOrigin)
 	header.Set("X-Apple-Widget-Key", c.serviceKey)
 	header.Set("X-Requested-With", "XMLHttpRequest")
 	header.Set("X-Apple-I-Require-UE", "true")
 	header.Set("X-Apple-Auth-Attributes", c.authAttr)
 	header.Set("X-Apple-Widget-Key", c.clientId)
-	header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36")
+	header.Set("User-Agent", c.userAgent)
 	header.Set("X-Apple-Mandate-Security-Upgrade", "0")
 	header.Set("X-Apple-Oauth-Client-Id", c.clientId)
-	header.Set("X-Apple-I-FD-Client-Info", `{"U":"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36","L":"en-US","Z":"GMT-04:00","V":"1.1","F":".ta44j1e3NlY5BNlY5BSs5uQ32SCVgdI.AqWJ4EKKw0fVD_DJhCizgzH_y3EjNklY_ia4WFL264HRe4FSr_JzC1zJ6rgNNlY5BNp55BNlan0Os5Apw.BS1"}`)
+	header.Set("X-Apple-I-FD-Client-Info", c.fdClientInfo())
 	header.Set("X-Apple-Oauth-Client-Type", `firstPartyAuth`)
-	header.Set("X-Apple-Oauth-Redirect-URI", `https://www.icloud.com`)
+	header.Set("X-Apple-Oauth-Redirect-URI", region.HomeOrigin)
 	header.Set("X-Apple-Oauth-Require-Grant-Code", `true`)
 	header.Set("X-Apple-Oauth-Response-Mode", `web_message`)
 	header.Set("X-Apple-Oauth-Response-Type", `code`)
@@ -120,3 +233,22 @@ func (c *Client) updateRequestHeaders(header http.Header) http.Header {
 
 	return header
 }
+
+// fdClientInfo returns the browser fingerprint Apple expects in X-Apple-I-FD-Client-Info.
+func (c *Client) fdClientInfo() string {
+	info, _ := json.Marshal(struct {
+		U string `json:"U"`
+		L string `json:"L"`
+		Z string `json:"Z"`
+		V string `json:"V"`
+		F string `json:"F"`
+	}{
+		U: c.userAgent,
+		L: c.locale,
+		Z: c.utcOffset(),
+		V: "1.1",
+		F: ".ta44j1e3NlY5BNlY5BSs5uQ32SCVgdI.AqWJ4EKKw0fVD_DJhCizgzH_y3EjNklY_ia4WFL264HRe4FSr_JzC1zJ6rgNNlY5BNp55BNlan0Os5Apw.BS1",
+	})
+
+	return string(info)
+}
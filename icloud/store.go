@@ -0,0 +1,272 @@
+package icloud
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// SessionStore persists authenticated sessions between processes. When a store is set
+// on the Client, Login first tries to resume the stored session and only runs the full
+// SRP and 2FA flow if that session is no longer valid. After a full login the new
+// session is saved back to the store.
+//
+// Sessions are keyed by account username. Load returns ErrSessionNotFound when no
+// session has been saved for the account.
+type SessionStore interface {
+	Load(username string) (*SessionState, error)
+	Save(username string, state *SessionState) error
+	Delete(username string) error
+}
+
+// * MemorySessionStore keeps sessions in process memory. Useful for tests and for sharing
+// a session between several clients for the same account.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string][]byte
+}
+
+// NewMemorySessionStore returns an empty in-memory session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string][]byte)}
+}
+
+func (s *MemorySessionStore) Load(username string) (*SessionState, error) {
+	s.mu.Lock()
+	data, ok := s.sessions[sessionKey(username)]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	// Sessions are stored marshalled so callers can never share state through the store.
+	var state SessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func (s *MemorySessionStore) Save(username string, state *SessionState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.sessions[sessionKey(username)] = data
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(username string) error {
+	s.mu.Lock()
+	delete(s.sessions, sessionKey(username))
+	s.mu.Unlock()
+
+	return nil
+}
+
+const (
+	fileStoreMagic   = "ICS1"
+	fileStoreSaltLen = 16
+
+	// scrypt parameters recommended for interactive logins as of 2017.
+	fileStoreScryptN = 32768
+	fileStoreScryptR = 8
+	fileStoreScryptP = 1
+)
+
+// * FileSessionStore saves each session to its own file in a directory, encrypted with
+// AES-256-GCM. The key is derived from a passphrase with scrypt and a random per-file
+// salt, so files can not be read or modified without the passphrase.
+type FileSessionStore struct {
+	dir        string
+	passphrase []byte
+
+	mu sync.Mutex
+}
+
+// NewFileSessionStore returns a store that keeps encrypted sessions in dir, creating the
+// directory if needed. The passphrase must be the same every time the store is opened.
+func NewFileSessionStore(dir, passphrase string) (*FileSessionStore, error) {
+	if passphrase == "" {
+		return nil, errors.New("file session store: passphrase is required")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("file session store: %w", err)
+	}
+
+	return &FileSessionStore{
+		dir:        dir,
+		passphrase: []byte(passphrase),
+	}, nil
+}
+
+func (s *FileSessionStore) Load(username string) (*SessionState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path(username))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := s.decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var state SessionState
+	if err := json.Unmarshal(plaintext, &state); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSessionState, err)
+	}
+
+	return &state, nil
+}
+
+func (s *FileSessionStore) Save(username string, state *SessionState) error {
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Write to a temp file first so a crash never leaves a truncated session behind.
+	tmp, err := os.CreateTemp(s.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(username))
+}
+
+func (s *FileSessionStore) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(username))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path returns the session file for the account. Usernames are hashed so the file
+// names do not leak the Apple ID.
+func (s *FileSessionStore) path(username string) string {
+	return filepath.Join(s.dir, sessionKey(username)+".session")
+}
+
+// encrypt seals plaintext as magic | salt | nonce | ciphertext.
+func (s *FileSessionStore) encrypt(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, fileStoreSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := s.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(fileStoreMagic)+len(salt)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, fileStoreMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+
+	// The header is authenticated as additional data so it can not be swapped.
+	return aead.Seal(out, nonce, plaintext, out), nil
+}
+
+func (s *FileSessionStore) decrypt(data []byte) ([]byte, error) {
+	if len(data) < len(fileStoreMagic)+fileStoreSaltLen || !bytes.Equal(data[:len(fileStoreMagic)], []byte(fileStoreMagic)) {
+		return nil, fmt.Errorf("%w: not a session file", ErrInvalidSessionState)
+	}
+
+	salt := data[len(fileStoreMagic) : len(fileStoreMagic)+fileStoreSaltLen]
+
+	aead, err := s.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(fileStoreMagic) + fileStoreSaltLen + aead.NonceSize()
+	if len(data) < headerLen {
+		return nil, fmt.Errorf("%w: truncated session file", ErrInvalidSessionState)
+	}
+
+	nonce := data[len(fileStoreMagic)+fileStoreSaltLen : headerLen]
+
+	plaintext, err := aead.Open(nil, nonce, data[headerLen:], data[:headerLen])
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong passphrase or corrupted session file", ErrInvalidSessionState)
+	}
+
+	return plaintext, nil
+}
+
+func (s *FileSessionStore) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(s.passphrase, salt, fileStoreScryptN, fileStoreScryptR, fileStoreScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sessionKey normalizes a username into the key sessions are stored under.
+func sessionKey(username string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(username))))
+	return hex.EncodeToString(sum[:])
+}
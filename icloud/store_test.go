@@ -0,0 +1,222 @@
+package icloud_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+)
+
+func testSessionState() *icloud.SessionState {
+	return &icloud.SessionState{
+		Version:    2,
+		Username:   "user@icloud.com",
+		SavedAt:    time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Region:     "global",
+		AuthToken:  "auth-token",
+		TrustToken: "trust-token",
+		FrameID:    "frame-id",
+		ClientID:   "client-id",
+		Scnt:       "scnt",
+		Dsid:       "123456789",
+		Webservices: map[string]icloud.Webservice{
+			"premiummailsettings": {URL: "https://p42-maildomainws.icloud.com", Status: "active"},
+		},
+		Cookies: map[string][]icloud.SessionCookie{
+			"https://www.icloud.com": {{Name: "X-APPLE-WEBAUTH-TOKEN", Value: "cookie", Domain: ".icloud.com", Path: "/", Secure: true}},
+		},
+	}
+}
+
+// sessionFile returns the only session file in dir.
+func sessionFile(t *testing.T, dir string) string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("found session files %v, want one", files)
+	}
+	return files[0]
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := icloud.NewMemorySessionStore()
+
+	if _, err := store.Load("user@icloud.com"); !errors.Is(err, icloud.ErrSessionNotFound) {
+		t.Fatalf("Load of an unknown account = %v, want ErrSessionNotFound", err)
+	}
+
+	state := testSessionState()
+	if err := store.Save("user@icloud.com", state); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stored copy is not shared with the caller
+	state.AuthToken = "changed"
+
+	got, err := store.Load(" USER@icloud.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testSessionState()) {
+		t.Errorf("Load = %+v, want %+v", got, testSessionState())
+	}
+
+	if err := store.Delete("user@icloud.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("user@icloud.com"); !errors.Is(err, icloud.ErrSessionNotFound) {
+		t.Errorf("Load after Delete = %v, want ErrSessionNotFound", err)
+	}
+	if err := store.Delete("user@icloud.com"); err != nil {
+		t.Errorf("Delete of a missing session = %v", err)
+	}
+}
+
+func TestFileSessionStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	store, err := icloud.NewFileSessionStore(dir, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Load("user@icloud.com"); !errors.Is(err, icloud.ErrSessionNotFound) {
+		t.Fatalf("Load of a missing file = %v, want ErrSessionNotFound", err)
+	}
+
+	if err := store.Save("user@icloud.com", testSessionState()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		// a store opened again with the same passphrase reads the session
+		reopened, err := icloud.NewFileSessionStore(dir, "passphrase")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := reopened.Load("User@iCloud.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, testSessionState()) {
+			t.Errorf("Load = %+v, want %+v", got, testSessionState())
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := sessionFile(t, dir)
+		if strings.Contains(path, "user") {
+			t.Errorf("the file name %s leaks the Apple ID", filepath.Base(path))
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"auth-token", "trust-token", "123456789", "user@icloud.com"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("the file contains %q in the clear", secret)
+			}
+		}
+
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes are not enforced on Windows")
+		}
+		for path, want := range map[string]os.FileMode{path: 0o600, dir: 0o700} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != want {
+				t.Errorf("%s has mode %v, want %v", filepath.Base(path), info.Mode().Perm(), want)
+			}
+		}
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		wrong, err := icloud.NewFileSessionStore(dir, "not the passphrase")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wrong.Load("user@icloud.com"); !errors.Is(err, icloud.ErrInvalidSessionState) {
+			t.Errorf("Load with the wrong passphrase = %v, want ErrInvalidSessionState", err)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		path := sessionFile(t, dir)
+		original, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.WriteFile(path, original, 0o600)
+
+		// the file is magic (4) | salt (16) | nonce (12) | ciphertext and tag
+		tests := []struct {
+			name   string
+			tamper func([]byte) []byte
+		}{
+			{"magic", flipByte(0)},
+			{"salt", flipByte(4)},
+			{"nonce", flipByte(20)},
+			{"ciphertext", flipByte(32)},
+			{"tag", flipByte(-1)},
+			{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+			{"header only", func(b []byte) []byte { return b[:24] }},
+			{"empty", func(b []byte) []byte { return nil }},
+		}
+
+		for _, tt := range tests {
+			data := tt.tamper(append([]byte(nil), original...))
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			state, err := store.Load("user@icloud.com")
+			if !errors.Is(err, icloud.ErrInvalidSessionState) || state != nil {
+				t.Errorf("%s: Load = %v, %v, want ErrInvalidSessionState", tt.name, state, err)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := store.Delete("user@icloud.com"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Load("user@icloud.com"); !errors.Is(err, icloud.ErrSessionNotFound) {
+			t.Errorf("Load after Delete = %v, want ErrSessionNotFound", err)
+		}
+		if err := store.Delete("user@icloud.com"); err != nil {
+			t.Errorf("Delete of a missing session = %v", err)
+		}
+	})
+}
+
+func TestFileSessionStoreNeedsPassphrase(t *testing.T) {
+	if _, err := icloud.NewFileSessionStore(t.TempDir(), ""); err == nil {
+		t.Error("NewFileSessionStore accepted an empty passphrase")
+	}
+}
+
+// flipByte returns a tamper function that flips the bits of the byte at i, counted from
+// the end if i is negative.
+func flipByte(i int) func([]byte) []byte {
+	return func(b []byte) []byte {
+		j := i
+		if j < 0 {
+			j += len(b)
+		}
+		b[j] ^= 0xff
+		return b
+	}
+}
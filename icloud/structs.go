@@ -25,6 +25,12 @@ type AuthCompleteReq struct {
 	M2          string   `json:"m2"`
 }
 
+// AuthCompleteResp is the body of a signin/complete response. M2 is the server's SRP proof.
+type AuthCompleteResp struct {
+	M2       string `json:"m2,omitempty"`
+	AuthType string `json:"authType,omitempty"`
+}
+
 type SecurityCode struct {
 	Code string `json:"code"`
 }
@@ -39,6 +45,35 @@ type TwoFactorCodeFromPhoneRequest struct {
 	Mode         string         `json:"mode,omitempty"`
 }
 
+// AuthOptionsResp lists the two-factor options available for the account being signed in.
+type AuthOptionsResp struct {
+	TrustedPhoneNumbers   []TrustedPhoneNumber `json:"trustedPhoneNumbers"`
+	TrustedPhoneNumber    *TrustedPhoneNumber  `json:"trustedPhoneNumber,omitempty"`
+	SecurityCode          SecurityCodeOptions  `json:"securityCode"`
+	AuthenticationType    string               `json:"authenticationType"`
+	TrustedDeviceCount    int                  `json:"trustedDeviceCount"`
+	NoTrustedDevices      bool                 `json:"noTrustedDevices"`
+	HideSendSMSCodeOption bool                 `json:"hideSendSMSCodeOption"`
+}
+
+// TrustedPhoneNumber is a phone number that can receive two-factor codes. Only the last
+// digits of the number are revealed.
+type TrustedPhoneNumber struct {
+	ID                 int    `json:"id"`
+	NumberWithDialCode string `json:"numberWithDialCode"`
+	ObfuscatedNumber   string `json:"obfuscatedNumber"`
+	LastTwoDigits      string `json:"lastTwoDigits"`
+	PushMode           string `json:"pushMode"`
+}
+
+type SecurityCodeOptions struct {
+	Length                int  `json:"length"`
+	TooManyCodesSent      bool `json:"tooManyCodesSent"`
+	TooManyCodesValidated bool `json:"tooManyCodesValidated"`
+	SecurityCodeLocked    bool `json:"securityCodeLocked"`
+	SecurityCodeCooldown  bool `json:"securityCodeCooldown"`
+}
+
 // ServiceError represents a Apple service error.
 type ServiceError struct {
 	Code    string `json:"code,omitempty"`
@@ -348,3 +383,79 @@ type MsgPart struct {
 	GUID    string `json:"guid"`
 	Content string `json:"content"`
 }
+
+// ---- Account ----
+
+// AccountInfo describes the signed in account, as reported in dsInfo at login.
+type AccountInfo struct {
+	Dsid           string   `json:"dsid"`
+	FullName       string   `json:"fullName"`
+	FirstName      string   `json:"firstName"`
+	LastName       string   `json:"lastName"`
+	AppleID        string   `json:"appleId"`
+	AppleIDAliases []string `json:"appleIdAliases"`
+	ICloudAlias    string   `json:"iCloudAppleIdAlias"`
+	PrimaryEmail   string   `json:"primaryEmail"`
+	CountryCode    string   `json:"countryCode"`
+	Locale         string   `json:"locale"`
+	LanguageCode   string   `json:"languageCode"`
+	ManagedAppleID bool     `json:"isManagedAppleID"`
+
+	// ICloudPlus reports an active iCloud+ subscription, which Hide My Email requires.
+	ICloudPlus bool `json:"isHideMyEmailSubscriptionActive"`
+	// HideMyEmailAvailable reports whether Hide My Email is offered in the account's country.
+	HideMyEmailAvailable bool `json:"isHideMyEmailFeatureAvailable"`
+
+	// Family membership, from the account's family details. Left zero if they could not
+	// be fetched.
+	FamilyMember    bool     `json:"familyMember"`
+	FamilyOrganizer bool     `json:"familyOrganizer"`
+	FamilyMembers   []string `json:"familyMembers,omitempty"` // dsids of all members
+
+	Webservices map[string]Webservice `json:"webservices"`
+}
+
+// HideMyEmailEnabled reports whether ReserveHME can be expected to work for the account.
+func (a *AccountInfo) HideMyEmailEnabled() bool {
+	ws, ok := a.Webservices[wsPremiumMailSettings]
+	return a.ICloudPlus && ok && ws.Status != "inactive"
+}
+
+type familyDetailsResp struct {
+	IsMemberOfFamily bool `json:"isMemberOfFamily"`
+	Family           struct {
+		Organizer string   `json:"organizer"`
+		Members   []string `json:"members"`
+	} `json:"family"`
+}
+
+// StorageUsage is the account's iCloud storage quota and what it is used by.
+type StorageUsage struct {
+	Info    StorageUsageInfo    `json:"storageUsageInfo"`
+	ByMedia []StorageMediaUsage `json:"storageUsageByMedia"`
+	Quota   StorageQuotaStatus  `json:"quotaStatus"`
+}
+
+type StorageUsageInfo struct {
+	UsedBytes     int64 `json:"usedStorageInBytes"`
+	TotalBytes    int64 `json:"totalStorageInBytes"`
+	CompBytes     int64 `json:"compStorageInBytes"`
+	CommerceBytes int64 `json:"commerceStorageInBytes"`
+}
+
+// StorageMediaUsage is the storage used by one kind of data, e.g. "photos", "mail" or "backup".
+type StorageMediaUsage struct {
+	MediaKey     string `json:"mediaKey"`
+	DisplayLabel string `json:"displayLabel"`
+	DisplayColor string `json:"displayColor"`
+	UsageBytes   int64  `json:"usageInBytes"`
+}
+
+type StorageQuotaStatus struct {
+	OverQuota        bool `json:"overQuota"`
+	HaveMaxQuotaTier bool `json:"haveMaxQuotaTier"`
+	AlmostFull       bool `json:"almost-full"`
+	PaidQuota        bool `json:"paidQuota"`
+}
+
+// ---- Account end ----
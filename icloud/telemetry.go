@@ -0,0 +1,184 @@
+package icloud
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the OpenTelemetry instrumentation scope of the client's spans and
+// metrics.
+const instrumentationName = "github.com/Johnw7789/Go-iClient/icloud"
+
+var (
+	attrService  = attribute.Key("icloud.service")
+	attrEndpoint = attribute.Key("icloud.endpoint")
+)
+
+// telemetry holds the client's tracer and instruments. A nil *telemetry records nothing,
+// and each half is only set if its provider was given, see WithTracerProvider and
+// WithMeterProvider.
+type telemetry struct {
+	tracer trace.Tracer
+
+	requests metric.Int64Counter
+	errors   metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+// newTelemetry creates the tracer and instruments from the given providers, either of
+// which may be nil. It returns nil if both are.
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) (*telemetry, error) {
+	if tp == nil && mp == nil {
+		return nil, nil
+	}
+
+	t := &telemetry{}
+	if tp != nil {
+		t.tracer = tp.Tracer(instrumentationName)
+	}
+
+	if mp != nil {
+		meter := mp.Meter(instrumentationName)
+
+		var err error
+		t.requests, err = meter.Int64Counter("icloud.client.requests",
+			metric.WithDescription("Requests sent to Apple, by endpoint and status."),
+			metric.WithUnit("{request}"))
+		if err != nil {
+			return nil, err
+		}
+
+		t.errors, err = meter.Int64Counter("icloud.client.errors",
+			metric.WithDescription("Requests that failed or got an error status from Apple, e.g. 450, 421 or 409."),
+			metric.WithUnit("{request}"))
+		if err != nil {
+			return nil, err
+		}
+
+		t.duration, err = meter.Float64Histogram("icloud.client.request.duration",
+			metric.WithDescription("Latency of requests sent to Apple, by service."),
+			metric.WithUnit("s"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// operation is the span of one public client call.
+type operation struct {
+	span trace.Span
+}
+
+// startOperation starts the span of a public call, e.g. "hme.Reserve". The requests it
+// makes are traced as children of it through the returned context.
+func (c *Client) startOperation(ctx context.Context, name string) (context.Context, operation) {
+	if c.telemetry == nil || c.telemetry.tracer == nil {
+		return ctx, operation{}
+	}
+
+	ctx, span := c.telemetry.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, operation{span: span}
+}
+
+// end ends the operation's span, marking it failed if err is set.
+func (op operation) end(err error) {
+	if op.span == nil {
+		return
+	}
+
+	if err != nil {
+		err = spanError(err)
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Error())
+	}
+	op.span.End()
+}
+
+// spanError returns err as it is recorded on spans. Transport errors lose the request URL,
+// which carries the dsid and client id, as they do in the logs.
+func spanError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return errors.New(transportError(err))
+	}
+	return err
+}
+
+// startRequest starts the client span of a request to ep. The span is not propagated to
+// Apple.
+func (c *Client) startRequest(ep endpoint, req *http.Request) trace.Span {
+	if c.telemetry == nil || c.telemetry.tracer == nil {
+		return nil
+	}
+
+	_, span := c.telemetry.tracer.Start(req.Context(), req.Method+" "+ep.String(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrService.String(ep.service()),
+			attrEndpoint.String(ep.String()),
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+		))
+
+	return span
+}
+
+// recordRequest ends the span of a finished request and records it in the metrics.
+// Transport errors and statuses of 400 and above count as errors.
+func (c *Client) recordRequest(ctx context.Context, span trace.Span, ep endpoint, resp *http.Response, err error, latency time.Duration) {
+	if c.telemetry == nil {
+		return
+	}
+
+	attrs := []attribute.KeyValue{attrService.String(ep.service()), attrEndpoint.String(ep.String())}
+
+	var errorType string
+	switch {
+	case err != nil:
+		errorType = "transport"
+	case resp.StatusCode >= 400:
+		errorType = strconv.Itoa(resp.StatusCode)
+	}
+
+	if span != nil {
+		if resp != nil {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		}
+		if err != nil {
+			span.RecordError(spanError(err))
+		}
+		if errorType != "" {
+			span.SetAttributes(semconv.ErrorTypeKey.String(errorType))
+			span.SetStatus(codes.Error, errorType)
+		}
+		span.End()
+	}
+
+	if c.telemetry.requests == nil {
+		return
+	}
+
+	c.telemetry.duration.Record(ctx, latency.Seconds(), metric.WithAttributes(attrs...))
+
+	if resp != nil {
+		attrs = append(attrs, semconv.HTTPResponseStatusCode(resp.StatusCode))
+	}
+	c.telemetry.requests.Add(ctx, 1, metric.WithAttributes(attrs...))
+
+	if errorType != "" {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(errorType))
+		c.telemetry.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+}
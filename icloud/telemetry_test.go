@@ -0,0 +1,309 @@
+package icloud_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type telemetryRecorder struct {
+	spans  *tracetest.InMemoryExporter
+	reader *sdkmetric.ManualReader
+
+	tp *sdktrace.TracerProvider
+	mp *sdkmetric.MeterProvider
+}
+
+func newTelemetryRecorder() *telemetryRecorder {
+	r := &telemetryRecorder{
+		spans:  tracetest.NewInMemoryExporter(),
+		reader: sdkmetric.NewManualReader(),
+	}
+	r.tp = sdktrace.NewTracerProvider(sdktrace.WithSyncer(r.spans))
+	r.mp = sdkmetric.NewMeterProvider(sdkmetric.WithReader(r.reader))
+	return r
+}
+
+func (r *telemetryRecorder) metrics(t *testing.T) map[string]metricdata.Aggregation {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := r.reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+func hasAttrs(set attribute.Set, want ...attribute.KeyValue) bool {
+	for _, kv := range want {
+		if v, ok := set.Value(kv.Key); !ok || v != kv.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func spanAttrs(span tracetest.SpanStub) attribute.Set {
+	return attribute.NewSet(span.Attributes...)
+}
+
+func TestTelemetry(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+
+	err := srv.AddAccount(icloudtest.Account{AppleID: "user@icloud.com", Password: "password", ICloudPlus: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := newTelemetryRecorder()
+	client, err := srv.NewClient("user@icloud.com", "password",
+		icloud.WithTracerProvider(rec.tp), icloud.WithMeterProvider(rec.mp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(nil); err != nil {
+		t.Fatal(err)
+	}
+	rec.spans.Reset()
+
+	if _, err := client.ReserveHME("label", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.InjectFailure(icloudtest.Failure{Endpoint: "hme.reserve", Status: 409, Times: 1})
+	if _, err := client.ReserveHME("label", ""); err == nil {
+		t.Fatal("ReserveHME succeeded despite the injected failure")
+	}
+
+	t.Run("spans", func(t *testing.T) {
+		var ops []tracetest.SpanStub
+		children := make(map[trace.SpanID][]tracetest.SpanStub)
+		for _, span := range rec.spans.GetSpans() {
+			if span.Parent.IsValid() {
+				children[span.Parent.SpanID()] = append(children[span.Parent.SpanID()], span)
+			} else {
+				ops = append(ops, span)
+			}
+		}
+
+		if len(ops) != 2 {
+			t.Fatalf("got %d root spans, want one per ReserveHME", len(ops))
+		}
+
+		for i, op := range ops {
+			if op.Name != "hme.Reserve" || op.SpanKind != trace.SpanKindInternal {
+				t.Errorf("root span %d is %s of kind %s, want the internal hme.Reserve", i, op.Name, op.SpanKind)
+			}
+
+			requests := children[op.SpanContext.SpanID()]
+			if len(requests) != 2 {
+				t.Fatalf("hme.Reserve %d has %d child spans, want generate and reserve", i, len(requests))
+			}
+
+			for _, req := range requests {
+				if req.SpanKind != trace.SpanKindClient || req.SpanContext.TraceID() != op.SpanContext.TraceID() {
+					t.Errorf("request span %s is of kind %s in another trace", req.Name, req.SpanKind)
+				}
+				if !hasAttrs(spanAttrs(req), attribute.String("icloud.service", "hme")) {
+					t.Errorf("request span %s has attributes %v", req.Name, req.Attributes)
+				}
+			}
+
+			reserve := requests[1]
+			if reserve.Name != "POST hme.reserve" {
+				t.Fatalf("second request span is %s, want POST hme.reserve", reserve.Name)
+			}
+
+			want := []attribute.KeyValue{
+				attribute.String("icloud.endpoint", "hme.reserve"),
+				attribute.String("http.request.method", "POST"),
+				attribute.Int("http.response.status_code", 200),
+			}
+			wantStatus := codes.Unset
+			if i == 1 {
+				want[2] = attribute.Int("http.response.status_code", 409)
+				want = append(want, attribute.String("error.type", "409"))
+				wantStatus = codes.Error
+			}
+
+			if !hasAttrs(spanAttrs(reserve), want...) {
+				t.Errorf("reserve span %d has attributes %v, want %v", i, reserve.Attributes, want)
+			}
+			if reserve.Status.Code != wantStatus || op.Status.Code != wantStatus {
+				t.Errorf("reserve %d: request span status %s and operation status %s, want %s", i, reserve.Status.Code, op.Status.Code, wantStatus)
+			}
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		metrics := rec.metrics(t)
+
+		reserve := []attribute.KeyValue{
+			attribute.String("icloud.service", "hme"),
+			attribute.String("icloud.endpoint", "hme.reserve"),
+		}
+		count := func(name string, want ...attribute.KeyValue) int64 {
+			sum, ok := metrics[name].(metricdata.Sum[int64])
+			if !ok {
+				t.Fatalf("%s is %T, want an int64 sum", name, metrics[name])
+			}
+
+			var n int64
+			for _, dp := range sum.DataPoints {
+				if hasAttrs(dp.Attributes, want...) {
+					n += dp.Value
+				}
+			}
+			return n
+		}
+
+		if n := count("icloud.client.requests", append(reserve, attribute.Int("http.response.status_code", 200))...); n != 1 {
+			t.Errorf("counted %d reserve requests with status 200, want 1", n)
+		}
+		if n := count("icloud.client.requests", append(reserve, attribute.Int("http.response.status_code", 409))...); n != 1 {
+			t.Errorf("counted %d reserve requests with status 409, want 1", n)
+		}
+		if n := count("icloud.client.errors", reserve...); n != 1 {
+			t.Errorf("counted %d reserve errors, want 1", n)
+		}
+		if n := count("icloud.client.errors", append(reserve, attribute.String("error.type", "409"))...); n != 1 {
+			t.Errorf("counted %d reserve errors of type 409, want 1", n)
+		}
+		if n := count("icloud.client.errors", attribute.String("icloud.endpoint", "hme.generate")); n != 0 {
+			t.Errorf("counted %d errors for the successful generate requests", n)
+		}
+		if n := count("icloud.client.requests", attribute.String("icloud.service", "auth")); n == 0 {
+			t.Error("the login requests were not counted")
+		}
+
+		hist, ok := metrics["icloud.client.request.duration"].(metricdata.Histogram[float64])
+		if !ok {
+			t.Fatalf("icloud.client.request.duration is %T, want a float64 histogram", metrics["icloud.client.request.duration"])
+		}
+
+		var recorded uint64
+		for _, dp := range hist.DataPoints {
+			if hasAttrs(dp.Attributes, reserve...) {
+				if _, ok := dp.Attributes.Value("http.response.status_code"); ok {
+					t.Errorf("duration is recorded by status: %v", dp.Attributes.ToSlice())
+				}
+				recorded += dp.Count
+			}
+		}
+		if recorded != 2 {
+			t.Errorf("recorded the duration of %d reserve requests, want 2", recorded)
+		}
+	})
+}
+
+func TestTelemetryDisabled(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+
+	err := srv.AddAccount(icloudtest.Account{AppleID: "user@icloud.com", Password: "password", ICloudPlus: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a client without providers must not fall back to the global ones
+	rec := newTelemetryRecorder()
+	tp, mp := otel.GetTracerProvider(), otel.GetMeterProvider()
+	otel.SetTracerProvider(rec.tp)
+	otel.SetMeterProvider(rec.mp)
+	defer func() {
+		otel.SetTracerProvider(tp)
+		otel.SetMeterProvider(mp)
+	}()
+
+	client, err := srv.NewClient("user@icloud.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.InjectFailure(icloudtest.Failure{Endpoint: "hme.reserve", Status: 409, Times: 1})
+	client.ReserveHME("label", "")
+
+	if spans := rec.spans.GetSpans(); len(spans) != 0 {
+		t.Errorf("recorded %d spans without a TracerProvider", len(spans))
+	}
+	if metrics := rec.metrics(t); len(metrics) != 0 {
+		t.Errorf("recorded metrics without a MeterProvider: %v", metrics)
+	}
+}
+
+func TestTelemetryTransportErrorOmitsURL(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+
+	const dsid = "8765432109"
+	err := srv.AddAccount(icloudtest.Account{AppleID: "user@icloud.com", Password: "password", Dsid: dsid})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := newTelemetryRecorder()
+	client, err := srv.NewClient("user@icloud.com", "password", icloud.WithTracerProvider(rec.tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(nil); err != nil {
+		t.Fatal(err)
+	}
+	rec.spans.Reset()
+
+	// the validate URL carries the dsid and client id
+	srv.Close()
+	if _, err := client.ValidateSession(); err == nil {
+		t.Fatal("ValidateSession succeeded against a closed server")
+	}
+
+	spans := rec.spans.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the operation and its request", len(spans))
+	}
+
+	for _, span := range spans {
+		if span.Status.Code != codes.Error {
+			t.Errorf("span %s has status %s, want an error", span.Name, span.Status.Code)
+		}
+
+		texts := []string{span.Status.Description}
+		for _, event := range span.Events {
+			texts = append(texts, event.Name)
+			for _, attr := range event.Attributes {
+				texts = append(texts, attr.Value.Emit())
+			}
+		}
+		for _, attr := range span.Attributes {
+			texts = append(texts, attr.Value.Emit())
+		}
+
+		for _, text := range texts {
+			if strings.Contains(text, dsid) || strings.Contains(text, "clientId=") {
+				t.Errorf("span %s exports the request URL: %q", span.Name, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package icloud
+
+import (
+	nethttp "net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// Transport is the HTTP stack the client sends its requests through, together with the
+// cookie jar that holds the session cookies. The default is the tls-client HttpClient, which
+// mimics a browser's TLS fingerprint; any tls_client.HttpClient satisfies Transport.
+// NewStdTransport adapts a net/http client, e.g. to talk to an httptest.Server.
+type Transport interface {
+	Do(req *http.Request) (*http.Response, error)
+	GetCookies(u *url.URL) []*http.Cookie
+	SetCookies(u *url.URL, cookies []*http.Cookie)
+}
+
+// stdTransport adapts a net/http client to Transport.
+type stdTransport struct {
+	client *nethttp.Client
+}
+
+// NewStdTransport returns a Transport that sends requests through client. A cookie jar is
+// added if client has none. Use it with WithTransport to point the client at a test server
+// or to wrap the requests in your own instrumentation. Apple may treat the Go TLS
+// fingerprint differently from a browser's, so prefer the default transport against
+// Apple's servers.
+//
+// The standard cookie jar only hands back the name and value of each cookie, so sessions
+// exported from a client using it carry no cookie domains or expiry times.
+func NewStdTransport(client *nethttp.Client) Transport {
+	if client == nil {
+		client = &nethttp.Client{}
+	}
+	if client.Jar == nil {
+		jar, _ := cookiejar.New(nil) // never fails without options
+		client.Jar = jar
+	}
+
+	return &stdTransport{client: client}
+}
+
+func (t *stdTransport) Do(req *http.Request) (*http.Response, error) {
+	stdReq, err := nethttp.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.Header {
+		// fhttp keeps the header order under magic keys that must not go on the wire
+		if key == http.HeaderOrderKey || key == http.PHeaderOrderKey {
+			continue
+		}
+		stdReq.Header[key] = values
+	}
+	stdReq.ContentLength = req.ContentLength
+	stdReq.Host = req.Host
+
+	stdResp, err := t.client.Do(stdReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        stdResp.Status,
+		StatusCode:    stdResp.StatusCode,
+		Proto:         stdResp.Proto,
+		ProtoMajor:    stdResp.ProtoMajor,
+		ProtoMinor:    stdResp.ProtoMinor,
+		Header:        http.Header(stdResp.Header),
+		Body:          stdResp.Body,
+		ContentLength: stdResp.ContentLength,
+		Uncompressed:  stdResp.Uncompressed,
+		Request:       req,
+	}, nil
+}
+
+func (t *stdTransport) GetCookies(u *url.URL) []*http.Cookie {
+	stdCookies := t.client.Jar.Cookies(u)
+
+	cookies := make([]*http.Cookie, 0, len(stdCookies))
+	for _, cookie := range stdCookies {
+		cookies = append(cookies, &http.Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			Expires:  cookie.Expires,
+			MaxAge:   cookie.MaxAge,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		})
+	}
+
+	return cookies
+}
+
+func (t *stdTransport) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	stdCookies := make([]*nethttp.Cookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		stdCookies = append(stdCookies, &nethttp.Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			Expires:  cookie.Expires,
+			MaxAge:   cookie.MaxAge,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		})
+	}
+
+	t.client.Jar.SetCookies(u, stdCookies)
+}
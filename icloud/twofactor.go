@@ -0,0 +1,408 @@
+package icloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+// maxTwoFactorAttempts caps how many codes are submitted before Login gives up, so a
+// handler that keeps retrying can not get the account locked.
+const maxTwoFactorAttempts = 5
+
+// maxTwoFactorResends caps how many new codes a handler can request through ErrResendCode.
+// Every resend makes Apple send an SMS or push, and too many of them lock the account too.
+const maxTwoFactorResends = 3
+
+// TwoFactorMethodType is a way of receiving a two-factor code.
+type TwoFactorMethodType string
+
+const (
+	TwoFactorTrustedDevice TwoFactorMethodType = "trusteddevice"
+	TwoFactorSMS           TwoFactorMethodType = TwoFactorModeSMS
+	TwoFactorVoice         TwoFactorMethodType = TwoFactorModeVoice
+)
+
+// TwoFactorMethod is a single delivery option for the two-factor code. Phone is set for
+// SMS and voice methods.
+type TwoFactorMethod struct {
+	Type  TwoFactorMethodType
+	Phone *TrustedPhoneNumber
+}
+
+// TwoFactorChallenge describes the two-factor challenge for the account being signed in,
+// built from Apple's auth options response.
+type TwoFactorChallenge struct {
+	// Methods lists every way the code can be delivered, trusted devices first.
+	Methods []TwoFactorMethod
+
+	// PhoneNumbers are the account's trusted phone numbers, with only the last digits revealed.
+	PhoneNumbers []TrustedPhoneNumber
+
+	// CodeLength is the number of digits in the code, usually 6.
+	CodeLength int
+
+	// PushSent is true when Apple has already pushed a code to the trusted devices.
+	PushSent bool
+
+	// TrustedDeviceCount is the number of trusted devices the code can be pushed to.
+	TrustedDeviceCount int
+
+	// AuthenticationType is Apple's name for the account's second factor, "hsa2" for
+	// two-factor authentication.
+	AuthenticationType string
+
+	// TooManyCodesSent is true when Apple will not send another code for now, so asking
+	// for a resend is pointless; use a code already received or give up.
+	TooManyCodesSent bool
+
+	// Locked is true when Apple no longer accepts codes for this sign in because too many
+	// wrong ones were entered. Login can only be retried later.
+	Locked bool
+
+	defaultPhone *TrustedPhoneNumber
+}
+
+// DefaultMethod returns the method Apple would pick: the trusted devices if the account
+// has any, otherwise the default trusted phone number.
+func (ch *TwoFactorChallenge) DefaultMethod() (TwoFactorMethod, error) {
+	if ch.PushSent {
+		return TwoFactorMethod{Type: TwoFactorTrustedDevice}, nil
+	}
+
+	phone := ch.defaultPhone
+	if phone == nil && len(ch.PhoneNumbers) > 0 {
+		phone = &ch.PhoneNumbers[0]
+	}
+	if phone == nil {
+		return TwoFactorMethod{}, ErrNoTwoFactorMethod
+	}
+
+	methodType := TwoFactorSMS
+	if phone.PushMode == TwoFactorModeVoice {
+		methodType = TwoFactorVoice
+	}
+
+	return TwoFactorMethod{Type: methodType, Phone: phone}, nil
+}
+
+// TwoFactorHandler drives the two-factor step of Login. Unlike an OTPProvider it is given
+// the full challenge, chooses how the code is delivered, can ask for the code to be sent
+// again and is told when a code was rejected, so it can retry without restarting Login.
+type TwoFactorHandler interface {
+	// SelectMethod picks how the code is delivered. It is called once per challenge and
+	// again every time Code asks for a resend.
+	SelectMethod(ctx context.Context, challenge *TwoFactorChallenge) (TwoFactorMethod, error)
+
+	// Code returns the code received through method. Returning ErrResendCode requests a
+	// new code; SelectMethod is then called again so a different method can be chosen.
+	// A fourth resend makes Login fail with ErrTooManyResends.
+	Code(ctx context.Context, challenge *TwoFactorChallenge, method TwoFactorMethod) (string, error)
+
+	// CodeRejected is called when Apple rejects a code. Returning nil asks Code for another
+	// code; returning an error aborts Login with that error.
+	CodeRejected(ctx context.Context, challenge *TwoFactorChallenge, attempt int) error
+}
+
+// otpHandler adapts an OTPProvider and optional PhoneSelector to a TwoFactorHandler.
+type otpHandler struct {
+	provider OTPProvider
+	selector PhoneSelector
+}
+
+func (h otpHandler) SelectMethod(ctx context.Context, ch *TwoFactorChallenge) (TwoFactorMethod, error) {
+	if h.selector != nil && len(ch.PhoneNumbers) > 0 {
+		phoneID, mode, err := h.selector(ch.PhoneNumbers)
+		if err != nil {
+			return TwoFactorMethod{}, fmt.Errorf("failed to select phone number: %w", err)
+		}
+
+		if phoneID != 0 {
+			for i := range ch.PhoneNumbers {
+				if ch.PhoneNumbers[i].ID != phoneID {
+					continue
+				}
+
+				methodType := TwoFactorSMS
+				if mode == TwoFactorModeVoice {
+					methodType = TwoFactorVoice
+				}
+
+				return TwoFactorMethod{Type: methodType, Phone: &ch.PhoneNumbers[i]}, nil
+			}
+
+			return TwoFactorMethod{}, fmt.Errorf("unknown trusted phone number id: %d", phoneID)
+		}
+	}
+
+	return ch.DefaultMethod()
+}
+
+// Code waits for the OTP provider, returning early if ctx is done. The provider can not be
+// interrupted, so it keeps running in the background until it returns.
+func (h otpHandler) Code(ctx context.Context, ch *TwoFactorChallenge, method TwoFactorMethod) (string, error) {
+	type result struct {
+		otp string
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		otp, err := h.provider()
+		done <- result{otp, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.otp, r.err
+	}
+}
+
+func (h otpHandler) CodeRejected(ctx context.Context, ch *TwoFactorChallenge, attempt int) error {
+	return ErrIncorrectOTP
+}
+
+// handleTwoFactor handles Two Factor authentication.
+func (c *Client) handleTwoFactor(ctx context.Context, signinResp *http.Response, handler TwoFactorHandler) error {
+	// extract `X-Apple-Id-Session-Id` and `scnt` from response
+	c.mu.Lock()
+	c.sessionID = signinResp.Header.Get(HdrXAppleIDSessionID)
+	c.scnt = signinResp.Header.Get(HdrScnt)
+	c.mu.Unlock()
+
+	if handler == nil {
+		return ErrTwoFactorRequired
+	}
+
+	options, err := c.getAuthOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	challenge := newTwoFactorChallenge(options)
+
+	method, err := handler.SelectMethod(ctx, challenge)
+	if err != nil {
+		return err
+	}
+
+	// * Trusted devices already received a push when the auth options were requested
+	if method.Type != TwoFactorTrustedDevice || !challenge.PushSent {
+		if err := c.requestCode(ctx, method); err != nil {
+			return err
+		}
+	}
+
+	attempt, resends := 0, 0
+	for {
+		code, err := handler.Code(ctx, challenge, method)
+		if errors.Is(err, ErrResendCode) {
+			resends++
+			if resends > maxTwoFactorResends {
+				return ErrTooManyResends
+			}
+
+			method, err = handler.SelectMethod(ctx, challenge)
+			if err != nil {
+				return err
+			}
+
+			if err := c.requestCode(ctx, method); err != nil {
+				return err
+			}
+
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get OTP: %w", err)
+		}
+
+		err = c.submitTwoFactor(ctx, code, method)
+		if !errors.Is(err, ErrIncorrectOTP) {
+			return err
+		}
+
+		attempt++
+		if attempt >= maxTwoFactorAttempts {
+			return err
+		}
+
+		if err := handler.CodeRejected(ctx, challenge, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+func newTwoFactorChallenge(options *AuthOptionsResp) *TwoFactorChallenge {
+	ch := &TwoFactorChallenge{
+		PhoneNumbers:       options.TrustedPhoneNumbers,
+		CodeLength:         options.SecurityCode.Length,
+		PushSent:           !options.NoTrustedDevices,
+		TrustedDeviceCount: options.TrustedDeviceCount,
+		AuthenticationType: options.AuthenticationType,
+		TooManyCodesSent:   options.SecurityCode.TooManyCodesSent,
+		Locked:             options.SecurityCode.SecurityCodeLocked,
+		defaultPhone:       options.TrustedPhoneNumber,
+	}
+
+	if !options.NoTrustedDevices {
+		ch.Methods = append(ch.Methods, TwoFactorMethod{Type: TwoFactorTrustedDevice})
+	}
+
+	for i := range ch.PhoneNumbers {
+		phone := &ch.PhoneNumbers[i]
+		if !options.HideSendSMSCodeOption {
+			ch.Methods = append(ch.Methods, TwoFactorMethod{Type: TwoFactorSMS, Phone: phone})
+		}
+		ch.Methods = append(ch.Methods, TwoFactorMethod{Type: TwoFactorVoice, Phone: phone})
+	}
+
+	return ch
+}
+
+// getAuthOptions fetches the available two-factor options. Requesting them also makes
+// Apple push a code to the account's trusted devices.
+func (c *Client) getAuthOptions(ctx context.Context) (*AuthOptionsResp, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.regionURL(authOptions), nil)
+	if err != nil {
+		return nil, err
+	}
+	// set required headers
+	req.Header = c.updateRequestHeaders(req.Header.Clone())
+
+	resp, err := c.roundTrip(authOptions, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	c.updateScnt(resp)
+
+	if resp.StatusCode != 200 {
+		return nil, readAPIError(authOptions, resp)
+	}
+
+	var options AuthOptionsResp
+	if err := json.NewDecoder(resp.Body).Decode(&options); err != nil {
+		return nil, fmt.Errorf("could not unmarshal auth options: %w", err)
+	}
+
+	return &options, nil
+}
+
+// requestCode asks Apple to send a code through method: a push to the trusted devices, or
+// an SMS or voice call to a trusted phone number.
+func (c *Client) requestCode(ctx context.Context, method TwoFactorMethod) error {
+	var req *http.Request
+	var err error
+
+	ep := requestPhoneCode
+	if method.Type == TwoFactorTrustedDevice {
+		ep = submitSecurityCode
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf(c.regionURL(submitSecurityCode), "trusteddevice"), nil)
+	} else {
+		if method.Phone == nil {
+			return fmt.Errorf("two-factor method %s requires a phone number", method.Type)
+		}
+
+		var data []byte
+		data, err = json.Marshal(TwoFactorCodeFromPhoneRequest{
+			PhoneNumber: &PhoneNumberID{ID: method.Phone.ID},
+			Mode:        string(method.Type),
+		})
+		if err != nil {
+			return fmt.Errorf("marshal request body: %w", err)
+		}
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, c.regionURL(requestPhoneCode), bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(HdrContentType, "application/json")
+	req.Header = c.updateRequestHeaders(req.Header.Clone())
+
+	resp, err := c.roundTrip(ep, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	c.updateScnt(resp)
+
+	if resp.StatusCode != 200 && resp.StatusCode != 202 && resp.StatusCode != 204 {
+		return readAPIError(ep, resp)
+	}
+
+	return nil
+}
+
+// submitTwoFactor submits the 2FA code, verifying it against the trusted devices or the
+// trusted phone number it was sent to. It returns ErrIncorrectOTP if Apple rejects the code.
+func (c *Client) submitTwoFactor(ctx context.Context, otp string, method TwoFactorMethod) error {
+	var body io.Reader
+	var codeType string
+
+	reqBody := TwoFactorCodeFromPhoneRequest{
+		SecurityCode: &SecurityCode{
+			Code: otp,
+		},
+	}
+
+	codeType = "trusteddevice"
+	if method.Type != TwoFactorTrustedDevice {
+		if method.Phone == nil {
+			return fmt.Errorf("two-factor method %s requires a phone number", method.Type)
+		}
+
+		codeType = "phone"
+		reqBody.PhoneNumber = &PhoneNumberID{ID: method.Phone.ID}
+		reqBody.Mode = string(method.Type)
+	}
+
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("marshal request body: %w", err)
+	}
+
+	body = bytes.NewReader(data)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(c.regionURL(submitSecurityCode), codeType), body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(HdrContentType, "application/json")
+	req.Header = c.updateRequestHeaders(req.Header.Clone())
+
+	resp, err := c.roundTrip(submitSecurityCode, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	c.updateScnt(resp)
+
+	switch resp.StatusCode {
+	// trusted device codes are accepted with 204, phone codes with 200
+	case 200, 204:
+		return nil
+
+	// Apple answers a wrong code with 400 and an "Incorrect verification code" service error
+	case 400:
+		apiErr := readAPIError(submitSecurityCode, resp)
+		apiErr.Err = ErrIncorrectOTP
+		return apiErr
+
+	default:
+		return readAPIError(submitSecurityCode, resp)
+	}
+}
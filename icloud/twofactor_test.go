@@ -0,0 +1,203 @@
+package icloud_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Johnw7789/Go-iClient/icloud"
+	"github.com/Johnw7789/Go-iClient/icloud/icloudtest"
+)
+
+// resend is the script entry that makes scriptedHandler ask for a new code.
+const resend = "resend"
+
+// scriptedHandler is a TwoFactorHandler that answers Code from codes in turn. The first
+// method is the challenge's default; every resend asks for an SMS to the first phone.
+type scriptedHandler struct {
+	codes  []string
+	reject error // returned from CodeRejected
+
+	challenges []*icloud.TwoFactorChallenge
+	methods    []icloud.TwoFactorMethodType
+	rejected   []int
+}
+
+func (h *scriptedHandler) SelectMethod(ctx context.Context, ch *icloud.TwoFactorChallenge) (icloud.TwoFactorMethod, error) {
+	h.challenges = append(h.challenges, ch)
+
+	method, err := ch.DefaultMethod()
+	if len(h.methods) > 0 {
+		method, err = icloud.TwoFactorMethod{Type: icloud.TwoFactorSMS, Phone: &ch.PhoneNumbers[0]}, nil
+	}
+	h.methods = append(h.methods, method.Type)
+	return method, err
+}
+
+func (h *scriptedHandler) Code(ctx context.Context, ch *icloud.TwoFactorChallenge, m icloud.TwoFactorMethod) (string, error) {
+	if len(h.codes) == 0 {
+		return "", errors.New("the script ran out of codes")
+	}
+
+	code := h.codes[0]
+	h.codes = h.codes[1:]
+	if code == resend {
+		return "", icloud.ErrResendCode
+	}
+	return code, nil
+}
+
+func (h *scriptedHandler) CodeRejected(ctx context.Context, ch *icloud.TwoFactorChallenge, attempt int) error {
+	h.rejected = append(h.rejected, attempt)
+	return h.reject
+}
+
+func TestTwoFactorHandler(t *testing.T) {
+	errGiveUp := errors.New("user gave up")
+
+	tests := []struct {
+		name    string
+		handler *scriptedHandler
+
+		wantErr      error
+		wantMethods  []icloud.TwoFactorMethodType
+		wantRejected []int
+		wantSubmits  int
+		wantSMS      int
+	}{
+		{
+			name:        "correct code",
+			handler:     &scriptedHandler{codes: []string{"123456"}},
+			wantMethods: []icloud.TwoFactorMethodType{icloud.TwoFactorTrustedDevice},
+			wantSubmits: 1,
+		},
+		{
+			name:         "wrong code, then a resend",
+			handler:      &scriptedHandler{codes: []string{"000000", resend, "123456"}},
+			wantMethods:  []icloud.TwoFactorMethodType{icloud.TwoFactorTrustedDevice, icloud.TwoFactorSMS},
+			wantRejected: []int{1},
+			wantSubmits:  2,
+			wantSMS:      1,
+		},
+		{
+			name:         "rejection aborts",
+			handler:      &scriptedHandler{codes: []string{"000000"}, reject: errGiveUp},
+			wantErr:      errGiveUp,
+			wantMethods:  []icloud.TwoFactorMethodType{icloud.TwoFactorTrustedDevice},
+			wantRejected: []int{1},
+			wantSubmits:  1,
+		},
+		{
+			name:         "too many wrong codes",
+			handler:      &scriptedHandler{codes: []string{"000001", "000002", "000003", "000004", "000005", "123456"}},
+			wantErr:      icloud.ErrIncorrectOTP,
+			wantMethods:  []icloud.TwoFactorMethodType{icloud.TwoFactorTrustedDevice},
+			wantRejected: []int{1, 2, 3, 4},
+			wantSubmits:  5,
+		},
+		{
+			name:    "too many resends",
+			handler: &scriptedHandler{codes: []string{"000000", resend, resend, resend, resend, "123456"}},
+			wantErr: icloud.ErrTooManyResends,
+			wantMethods: []icloud.TwoFactorMethodType{
+				icloud.TwoFactorTrustedDevice, icloud.TwoFactorSMS, icloud.TwoFactorSMS, icloud.TwoFactorSMS,
+			},
+			wantRejected: []int{1},
+			wantSubmits:  1,
+			wantSMS:      3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := icloudtest.NewServer()
+			defer srv.Close()
+
+			err := srv.AddAccount(icloudtest.Account{AppleID: "user@icloud.com", Password: "password", TwoFactor: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := srv.NewClient("user@icloud.com", "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.LoginWithHandler(tt.handler)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoginWithHandler = %v, want %v", err, tt.wantErr)
+			}
+			if (err == nil) != (srv.Calls("auth.trust") == 1) {
+				t.Errorf("login failed with %v but the session was trusted %d times", err, srv.Calls("auth.trust"))
+			}
+
+			if !reflect.DeepEqual(tt.handler.methods, tt.wantMethods) {
+				t.Errorf("selected methods %v, want %v", tt.handler.methods, tt.wantMethods)
+			}
+			if !reflect.DeepEqual(tt.handler.rejected, tt.wantRejected) {
+				t.Errorf("rejected attempts %v, want %v", tt.handler.rejected, tt.wantRejected)
+			}
+			if n := srv.Calls("auth.submitSecurityCode"); n != tt.wantSubmits {
+				t.Errorf("submitted %d codes, want %d", n, tt.wantSubmits)
+			}
+			if n := srv.Calls("auth.requestPhoneCode"); n != tt.wantSMS {
+				t.Errorf("requested %d SMS codes, want %d", n, tt.wantSMS)
+			}
+		})
+	}
+}
+
+func TestTwoFactorChallenge(t *testing.T) {
+	srv := icloudtest.NewServer()
+	defer srv.Close()
+
+	phones := []icloud.TrustedPhoneNumber{
+		{ID: 1, NumberWithDialCode: "+1 (•••) •••-••12", PushMode: icloud.TwoFactorModeSMS},
+		{ID: 2, NumberWithDialCode: "+49 ••••• •••34", PushMode: icloud.TwoFactorModeVoice},
+	}
+	err := srv.AddAccount(icloudtest.Account{
+		AppleID:       "user@icloud.com",
+		Password:      "password",
+		TwoFactor:     true,
+		Code:          "1234",
+		TrustedPhones: phones,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := srv.NewClient("user@icloud.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &scriptedHandler{codes: []string{"1234"}}
+	if err := client.LoginWithHandler(handler); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := handler.challenges[0]
+	if ch.CodeLength != 4 || !ch.PushSent || ch.TrustedDeviceCount != 1 || ch.AuthenticationType != "hsa2" {
+		t.Errorf("challenge = %+v, want a 4 digit hsa2 code pushed to one trusted device", ch)
+	}
+	if ch.TooManyCodesSent || ch.Locked {
+		t.Errorf("challenge = %+v, want codes to be accepted", ch)
+	}
+	if !reflect.DeepEqual(ch.PhoneNumbers, phones) {
+		t.Errorf("PhoneNumbers = %+v, want %+v", ch.PhoneNumbers, phones)
+	}
+
+	// every phone can take the code by SMS or voice call, whatever its push mode
+	var methods []string
+	for _, m := range ch.Methods {
+		method := string(m.Type)
+		if m.Phone != nil {
+			method += fmt.Sprintf(" %d", m.Phone.ID)
+		}
+		methods = append(methods, method)
+	}
+	want := []string{"trusteddevice", "sms 1", "voice 1", "sms 2", "voice 2"}
+	if !reflect.DeepEqual(methods, want) {
+		t.Errorf("Methods = %v, want %v", methods, want)
+	}
+}
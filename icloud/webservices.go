@@ -0,0 +1,89 @@
+package icloud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the webservices this package talks to, as listed in the accountLogin response.
+const (
+	wsAccount             = "account"
+	wsFindMe              = "findme"
+	wsContacts            = "contacts"
+	wsMail                = "mail"
+	wsMailGateway         = "mccgateway"
+	wsPremiumMailSettings = "premiummailsettings"
+)
+
+// * Webservice is an iCloud service the account has access to, as reported by Apple at
+// login. The URL points at the account's own partition, e.g. https://p52-mailws.icloud.com:443.
+type Webservice struct {
+	URL         string `json:"url"`
+	Status      string `json:"status,omitempty"`
+	PCSRequired bool   `json:"pcsRequired,omitempty"`
+}
+
+// endpointServices maps partition-local endpoints to the webservice whose URL they are
+// relative to. Their entries in endpoints only hold the path.
+var endpointServices = map[endpoint]string{
+	accountStorage: wsAccount,
+	accountFamily:  wsAccount,
+
+	hmeList:       wsPremiumMailSettings,
+	hmeGen:        wsPremiumMailSettings,
+	hmeReserve:    wsPremiumMailSettings,
+	hmeDeactivate: wsPremiumMailSettings,
+	hmeReactivate: wsPremiumMailSettings,
+	hmeDelete:     wsPremiumMailSettings,
+
+	mailInbox:       wsMailGateway,
+	mailMetadataGet: wsMailGateway,
+	mailGet:         wsMailGateway,
+	mailDelete:      wsMail,
+	mailDraft:       wsMail,
+	mailSend:        wsMailGateway,
+}
+
+// * Webservices returns the iCloud services available to the logged in account, keyed by
+// Apple's service name (mail, premiummailsettings, ckdatabasews, drivews, ...). It is empty
+// before Login.
+func (c *Client) Webservices() map[string]Webservice {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.copyWebservices()
+}
+
+// copyWebservices returns a copy of the webservices map. The caller must hold c.mu.
+func (c *Client) copyWebservices() map[string]Webservice {
+	services := make(map[string]Webservice, len(c.webservices))
+	for name, ws := range c.webservices {
+		services[name] = ws
+	}
+	return services
+}
+
+// webserviceURL returns the partition URL of the named webservice, or "" if the account
+// does not have it.
+func (c *Client) webserviceURL(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return strings.TrimSuffix(c.webservices[name].URL, "/")
+}
+
+// endpointURL returns the full URL of ep. Partition-local endpoints are resolved against
+// the account's webservices, so they fail with ErrServiceUnavailable before Login.
+func (c *Client) endpointURL(ep endpoint) (string, error) {
+	name, ok := endpointServices[ep]
+	if !ok {
+		return c.regionURL(ep), nil
+	}
+
+	base := c.webserviceURL(name)
+	if base == "" {
+		return "", fmt.Errorf("%s: %w", name, ErrServiceUnavailable)
+	}
+
+	return base + endpoints[ep], nil
+}
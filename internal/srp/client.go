@@ -1,12 +1,14 @@
 package srp
 
 import (
-	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"errors"
 	"math/big"
 )
 
+var ErrM2Mismatch = errors.New("M2 didn't check")
+
 type SRPClient struct {
 	Params     *SRPParams
 	Secret1    *big.Int
@@ -69,9 +71,10 @@ func (c *SRPClient) GetSessionKey() []byte {
 	return c.K
 }
 
+// CheckM2 verifies the server's proof M2 against the one expected by the client.
 func (c *SRPClient) CheckM2(M2 []byte) error {
-	if !bytes.Equal(c.M2, M2) {
-		return errors.New("M2 didn't check")
+	if len(c.M2) == 0 || subtle.ConstantTimeCompare(c.M2, M2) != 1 {
+		return ErrM2Mismatch
 	} else {
 		return nil
 	}
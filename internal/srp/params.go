@@ -2,6 +2,8 @@ package srp
 
 import (
 	"crypto"
+	_ "crypto/sha1" // registers crypto.SHA1 for the 1024 and 1536 bit groups
+	_ "crypto/sha256"
 	"fmt"
 	"math/big"
 )
@@ -86,15 +88,26 @@ func createParams(G int64, nBitLength int, hash crypto.Hash, NHex string) *SRPPa
 	return &p
 }
 
+// GetParams returns the group with the given bit length. The result is a copy, so setting
+// NoUserNameInX on it does not change the group for other callers.
 func GetParams(G int) *SRPParams {
 	params := knownGroups[G]
 	if params == nil {
 		panic(fmt.Sprintf("Params don't exist for %v", G))
 	} else {
-		return params
+		p := *params
+		return &p
 	}
 }
 
+// AppleParams returns the parameters Apple signs in with: the 2048-bit group with SHA-256,
+// leaving the username out of x.
+func AppleParams() *SRPParams {
+	params := GetParams(2048)
+	params.NoUserNameInX = true
+	return params
+}
+
 func (params *SRPParams) calculateA(a *big.Int) []byte {
 	ANum := new(big.Int)
 	ANum.Exp(params.G, a, params.N)
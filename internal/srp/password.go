@@ -0,0 +1,34 @@
+package srp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Password derivation protocols negotiated with Apple during signin/init.
+const (
+	ProtocolS2K   = "s2k"
+	ProtocolS2KFO = "s2k_fo"
+)
+
+// PasswordKey derives the SRP password from the user's password for the given protocol.
+// Both protocols run PBKDF2-HMAC-SHA256 over the SHA-256 digest of the password; s2k uses
+// the raw 32 byte digest while s2k_fo uses its lowercase hex encoding.
+func PasswordKey(protocol string, password, salt []byte, iterations int) ([]byte, error) {
+	digest := sha256.Sum256(password)
+
+	var input []byte
+	switch protocol {
+	case ProtocolS2K:
+		input = digest[:]
+	case ProtocolS2KFO:
+		input = []byte(hex.EncodeToString(digest[:]))
+	default:
+		return nil, fmt.Errorf("unsupported password protocol: %q", protocol)
+	}
+
+	return pbkdf2.Key(input, salt, iterations, sha256.Size, sha256.New), nil
+}
@@ -0,0 +1,81 @@
+package srp
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The expected keys were computed independently with Python's hashlib.pbkdf2_hmac over
+// the raw (s2k) and hex encoded (s2k_fo) SHA-256 digest of the password.
+var passwordKeyVectors = []struct {
+	password   string
+	salt       string // hex
+	iterations int
+	s2k        string
+	s2kFO      string
+}{
+	{
+		password:   "password",
+		salt:       "0102030405060708090a0b0c0d0e0f10",
+		iterations: 1000,
+		s2k:        "250cefb4fb5896d440932b74873e253355a810ab1a09c35bc942b14b8dfdd8d7",
+		s2kFO:      "baab6aa908a59c0ddaef910d18554361346e97186ccf7ad30b23030be0d8566f",
+	},
+	{
+		password:   "correct horse battery staple",
+		salt:       "73616c7453414c5473616c7453414c54",
+		iterations: 20000,
+		s2k:        "31814617fec614c3a560b97c6824f6722940e9696d883ca5c5007e30409070ad",
+		s2kFO:      "0a2c6ef89bc08e68aa93e798672bf21ebfed64512f9ce1a4d2eb3fa9adb2a2b7",
+	},
+	{
+		password:   "",
+		salt:       "73616c74",
+		iterations: 1,
+		s2k:        "731a29e33772cab47df692a67ea9e1c214920e3ce1538c6c0750af11fffed195",
+		s2kFO:      "5d7a8a8f626057b236b6cb8105501e06770b6b32f74f4058fcbfd7accbab6021",
+	},
+}
+
+func TestPasswordKey(t *testing.T) {
+	for _, v := range passwordKeyVectors {
+		salt, err := hex.DecodeString(v.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			protocol string
+			want     string
+		}{
+			{ProtocolS2K, v.s2k},
+			{ProtocolS2KFO, v.s2kFO},
+		} {
+			key, err := PasswordKey(tc.protocol, []byte(v.password), salt, v.iterations)
+			if err != nil {
+				t.Fatalf("%s(%q): %v", tc.protocol, v.password, err)
+			}
+			if got := hex.EncodeToString(key); got != tc.want {
+				t.Errorf("%s(%q, %d iterations) = %s, want %s", tc.protocol, v.password, v.iterations, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestPasswordKeyProtocolsDiffer(t *testing.T) {
+	s2k, _ := PasswordKey(ProtocolS2K, []byte("password"), []byte("salt"), 10)
+	s2kFO, _ := PasswordKey(ProtocolS2KFO, []byte("password"), []byte("salt"), 10)
+
+	if hex.EncodeToString(s2k) == hex.EncodeToString(s2kFO) {
+		t.Error("s2k and s2k_fo derived the same key")
+	}
+}
+
+func TestPasswordKeyUnsupportedProtocol(t *testing.T) {
+	for _, protocol := range []string{"", "s2k_fo2", "S2K", "plain"} {
+		key, err := PasswordKey(protocol, []byte("password"), []byte("salt"), 10)
+		if err == nil {
+			t.Errorf("PasswordKey(%q) = %x, want an error", protocol, key)
+		}
+	}
+}
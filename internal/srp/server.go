@@ -0,0 +1,102 @@
+package srp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrInvalidA   = errors.New("invalid client-supplied 'A', must not be 0 mod N")
+	ErrM1Mismatch = errors.New("M1 didn't check")
+)
+
+// SRPServer is the server side of the exchange, for tests that need to play Apple's part.
+type SRPServer struct {
+	Params     *SRPParams
+	Verifier   *big.Int
+	Secret2    *big.Int
+	Multiplier *big.Int
+	B          *big.Int
+	K          []byte
+	M2         []byte
+}
+
+// AppleVerifier returns the verifier for password as Apple stores it: the password is first
+// run through PasswordKey with the given protocol, salt and iteration count, and the
+// verifier is computed over AppleParams.
+func AppleVerifier(protocol string, password, salt []byte, iterations int) ([]byte, error) {
+	passKey, err := PasswordKey(protocol, password, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	// the identity is not part of x with Apple's parameters
+	return ComputeVerifier(AppleParams(), salt, nil, passKey), nil
+}
+
+// NewSRPServer returns a server holding verifier, as computed by ComputeVerifier or
+// AppleVerifier. b is the server's secret; a random one is used if it is empty.
+func NewSRPServer(param *SRPParams, verifier, b []byte) *SRPServer {
+	if len(b) == 0 {
+		b = make([]byte, 32)
+		rand.Read(b)
+	}
+
+	v := intFromBytes(verifier)
+	secret2 := intFromBytes(b)
+	multiplier := param.getMultiplier()
+
+	// B = (k*v + g^b) % N
+	gb := new(big.Int).Exp(param.G, secret2, param.N)
+	kv := new(big.Int).Mul(multiplier, v)
+	B := new(big.Int).Add(kv, gb)
+	B.Mod(B, param.N)
+
+	return &SRPServer{
+		Params:     param,
+		Verifier:   v,
+		Secret2:    secret2,
+		Multiplier: multiplier,
+		B:          B,
+	}
+}
+
+func (s *SRPServer) GetBBytes() []byte {
+	return padToN(s.B, s.Params)
+}
+
+// ProcessClientProof checks the client's proof M1 for the given username, salt and A, and
+// returns the server's proof M2. Both proofs are computed the way Apple's signin/complete
+// expects them, see calculateM1 and calculateM2.
+func (s *SRPServer) ProcessClientProof(username, salt, A, M1 []byte) ([]byte, error) {
+	bigA := intFromBytes(A)
+	if new(big.Int).Mod(bigA, s.Params.N).Sign() == 0 {
+		return nil, ErrInvalidA
+	}
+
+	u := s.Params.calculateU(bigA, s.B)
+
+	// S = (A * v^u) ^ b % N
+	vu := new(big.Int).Exp(s.Verifier, u, s.Params.N)
+	avu := new(big.Int).Mul(bigA, vu)
+	S := new(big.Int).Exp(avu, s.Secret2, s.Params.N)
+
+	K := s.Params.calculateK(padToN(S, s.Params))
+	padA := padToN(bigA, s.Params)
+
+	expected := s.Params.calculateM1(username, salt, padA, s.GetBBytes(), K)
+	if subtle.ConstantTimeCompare(expected, M1) != 1 {
+		return nil, ErrM1Mismatch
+	}
+
+	s.K = K
+	s.M2 = s.Params.calculateM2(padA, M1, K)
+
+	return s.M2, nil
+}
+
+func (s *SRPServer) GetSessionKey() []byte {
+	return s.K
+}
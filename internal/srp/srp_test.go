@@ -0,0 +1,394 @@
+package srp
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// rfc5054 holds the inputs of the test vectors in RFC 5054 Appendix B.
+var rfc5054 = struct {
+	I, P, s, a, b string
+}{
+	I: "alice",
+	P: "password123",
+	s: "BEB25379 D1A8581E B5A72767 3A2441EE",
+	a: "60975527 035CF2AD 1989806F 0407210B C81EDC04 E2762A56 AFD529DD DA2D4393",
+	b: "E487CB59 D31AC550 471E81F0 0F6928E0 1DDA08E9 74A004F4 9E61F5D1 05284D20",
+}
+
+// srpVectors are the values of the exchange with the RFC 5054 inputs for every known
+// group, named as in the RFC. The 1024 bit group's are Appendix B's; the others were
+// computed independently in Python from the formulas of RFC 5054 Section 2, with each
+// group's hash.
+var srpVectors = []struct {
+	group               int
+	k, x, v, A, B, u, S string
+}{
+	{
+		group: 1024,
+		k:     "7556AA04 5AEF2CDD 07ABAF0F 665C3E81 8913186F",
+		x:     "94B7555A ABE9127C C58CCF49 93DB6CF8 4D16C124",
+		v: `
+			7E273DE8 696FFC4F 4E337D05 B4B375BE B0DDE156 9E8FA00A 9886D812
+			9BADA1F1 822223CA 1A605B53 0E379BA4 729FDC59 F105B478 7E5186F5
+			C671085A 1447B52A 48CF1970 B4FB6F84 00BBF4CE BFBB1681 52E08AB5
+			EA53D15C 1AFF87B2 B9DA6E04 E058AD51 CC72BFC9 033B564E 26480D78
+			E955A5E2 9E7AB245 DB2BE315 E2099AFB`,
+		A: `
+			61D5E490 F6F1B795 47B0704C 436F523D D0E560F0 C64115BB 72557EC4
+			4352E890 3211C046 92272D8B 2D1A5358 A2CF1B6E 0BFCF99F 921530EC
+			8E393561 79EAE45E 42BA92AE ACED8251 71E1E8B9 AF6D9C03 E1327F44
+			BE087EF0 6530E69F 66615261 EEF54073 CA11CF58 58F0EDFD FE15EFEA
+			B349EF5D 76988A36 72FAC47B 0769447B`,
+		B: `
+			BD0C6151 2C692C0C B6D041FA 01BB152D 4916A1E7 7AF46AE1 05393011
+			BAF38964 DC46A067 0DD125B9 5A981652 236F99D9 B681CBF8 7837EC99
+			6C6DA044 53728610 D0C6DDB5 8B318885 D7D82C7F 8DEB75CE 7BD4FBAA
+			37089E6F 9C6059F3 88838E7A 00030B33 1EB76840 910440B1 B27AAEAE
+			EB4012B7 D7665238 A8E3FB00 4B117B58`,
+		u: "CE38B959 3487DA98 554ED47D 70A7AE5F 462EF019",
+		S: `
+			B0DC82BA BCF30674 AE450C02 87745E79 90A3381F 63B387AA F271A10D
+			233861E3 59B48220 F7C4693C 9AE12B0A 6F67809F 0876E2D0 13800D6C
+			41BB59B6 D5979B5C 00A172B4 A2A5903A 0BDCAF8A 709585EB 2AFAFA8F
+			3499B200 210DCC1F 10EB3394 3CD67FC8 8A2F39A4 BE5BEC4E C0A3212D
+			C346D7E4 74B29EDE 8A469FFE CA686E5A`,
+	},
+	{
+		group: 1536,
+		k:     "815A4561 E1A68B3F B7F6C03B BB3DAAA3 5D528D90",
+		x:     "94B7555A ABE9127C C58CCF49 93DB6CF8 4D16C124",
+		v: `
+			661B6FEA 4BBE1A09 DF5A17A9 ADF65D8A E890AA2F 2EA450EF B5200A5C
+			5DAE98FA 2FF0677E BB8C7001 2CC41B34 4A18D10C 79A64A7A C6B392DB
+			99E0C8F1 6D7A50AD BE295510 3DD38E5C 5A287DA9 F4264CF9 3FEDFF3A
+			A6CE47F1 8A53EC41 EA2E7BF3 6C53DE4B 22326655 8DC0E6DD EC513E05
+			9B087911 2637C7ED CA851633 8A4B5ACF 4D634133 DB26BA80 870B1EB3
+			42AD68C9 56F71A03 171D23A7 6A4C7351 99027155 B40103CA ECC131DE
+			D02A2664 C4E17A0A AD2B204D 600BB9BB DAB7387B 130C00DD`,
+		A: `
+			6DC951A1 7F41AB36 2936A100 F0DC2167 FCDB76C5 37A2788F CB201CDA
+			999556CF B20FBFC2 9D3A108D AD2E7EDD 7F82F2FD DA964351 E509AFF3
+			002837F4 AFA67663 0C2CE919 2D69DEF5 A8452804 B0E32A37 659396C3
+			9C2A2D11 4A3CAB02 AB70FCA3 21224049 C5F4D13D C0BC8101 43832EA6
+			D78E5B3B E5497AFB E27DFD76 D01E8F64 9437637E ACB376FA 08D31A75
+			9041362F C6824088 64925C02 BBB0CA9B B5342BBC 3C686DDD CCCBB65B
+			24E1BA74 5F50A8CE 91CF7795 86A811A3 9EEA12F8 063192E1`,
+		B: `
+			31461458 2B285342 82B61D2C 89814558 D6081BE2 2453FE21 1121A020
+			D6727750 86771FC4 44DAAEDC EB8A94AC AADBF099 5329959F 29C87525
+			C72045C8 9AF70B5F 47C12052 5C9EA544 4344554C 5DC18F16 A00ECD5E
+			61802304 16FE5226 4E91CD65 86C46F53 8644B494 77CCE705 D43E5C3C
+			FCC64B21 56266629 8D87EB57 98E891DB D575D7E3 0E01AF49 34C0C66A
+			E0B73B6D 75149484 33214547 D347E340 5FDD3832 6B047B9F 776D0DD7
+			B7A47574 FB3DE3C6 37C007C7 EE8357E1 82872DAF 47E4AF12`,
+		u: "D34E6D8A EF7C136A 7CF831B1 40B0F51F 19C3DEF5",
+		S: `
+			71463717 C0C5E9B0 14DF2F53 147FDA92 55670665 85EDCF3B 97AF5E10
+			5F477909 56FF32B5 63346D6F 409C6412 4BB6350F 98BFAEB9 BE83AD3B
+			5392E4E3 FB32F4A6 BD4798C4 B0F3F6BE F9CE4CFB 027F80E3 B912167B
+			05F40BB8 C5990E97 DA03D2C2 8DE1A4F7 0ECBB725 E9A5ED0B B0303FC0
+			9FB424E0 6DCCA8FD 8B9DA7ED 54BBDCE8 15C4E48B F43E5746 E1CD577F
+			95189B39 51F37ED4 28D76242 771399E3 0E2C47B0 A4889B39 1CCEF09B
+			B9039EA5 44584FB3 E9DEBD24 1883193C 64A8ED9D 9719F7EE`,
+	},
+	{
+		group: 2048,
+		k: `
+			05B9E8EF 059C6B32 EA59FC1D 322D37F0 4AA30BAE 5AA9003B 8321E21D
+			DB04E300`,
+		x: `
+			0065AC38 DFF8BC34 AE0F259E 91FBD0F4 CA2FA430 81C9050C EC7CAC20
+			D015F303`,
+		v: `
+			400272A6 1E185E23 784E28A1 6A149DC6 0A3790FD 45856F79 A7070C44
+			F7DA1CA2 2F711CD5 BC359217 1A875C78 12472916 DE2DCFAF C22F7DEA
+			D8F578F1 97054793 6F9EEC68 6BB3DF66 FF57F724 F6B907E8 3530812B
+			4FFDBF61 4153E9FB FED4FC6D 972DA70B B23F6CCD 36AD08B7 2567FE6B
+			CD2BACB7 13F2CDB9 DC8F81F8 97F489BB 393067D6 6237A3E0 61902E72
+			096D5AC1 CD1D06C1 CD648F7E 56DA5EC6 E0094C1B 448C5D63 AD2ADDEC
+			1E3D9A3A A7118A04 10E53434 DDBFFC60 EEF5B825 48BDA5A2 F5132094
+			84D32219 82CA7466 8A4D3733 0CC9CFE3 B10F0DB3 68293E43 026E3A01
+			440AC732 BC1CFB98 3B512D10 296F6951 EC5E5673 29AF8E58 D7C21EA6
+			C778B0BD`,
+		A: `
+			4B700F8D 48E69C9A AE40C684 AC7C7C03 121E2B76 02EB4C35 14804CCA
+			DA0ED401 9193A351 ECC65A6F 854EDE91 EB096E72 1B22D701 C7ADC64E
+			9CEDACD7 5F2E26BB 2F5E45DD 53DC8DBE AFFFE82A A49FCA05 73444691
+			212537A7 3CF80E25 03925820 5A7EDF47 49B30ADA F25877C6 2FCD09D6
+			613598BC D4BAF2A9 727A5370 6A278148 992B2ABB 23AD5D51 2D269E16
+			CA11BC08 95B5A3B5 EC4721CD E40A8C39 C796E94F 0BE86DBB EB33DA70
+			37018983 921ABA3F 5053195D 5AC1DA4E 567E3C0E 75D9E060 9F92E850
+			657B2BE4 771F415B 9CACC5C1 ECEDC301 33BF6474 F5022C65 19D78076
+			0CA4D8D3 B966B034 BD73877C 1B3B33F4 74B9C3C5 299A1968 F3E6CD3B
+			FE84445A`,
+		B: `
+			410813E3 063F3B45 32F2D364 13749F39 C26C5CEE B1346D39 95003C74
+			544C30CB A318F981 281607AE 68DBDC3B EE9F0544 ADA6B13D 8AC33217
+			B6709731 52CF03EF 03797615 E81DD305 342C2E3B B035321D 1FD71795
+			2E702B09 682102D0 A5AA25DC EE01784A 32B0684F 75626CA3 BF8AEC87
+			4F2DC11F 8926944B 06F9948E 8AD76490 25A58CD9 DCCDB6B2 10DE00E2
+			283E72BA AF93A39B 0417DFD1 888F841F 43D7D41C 75B58F65 4CCB2E8B
+			9C875C42 EDC34FD3 79620031 2F2ABD19 B7E2C54B 5702CD1A 7F4D79FD
+			F73BC418 C96466BA 122D4547 4AB6DB55 34177156 17F6C3B4 A8764279
+			F086ACC6 55E396F8 5812C90F 6F932CE0 586168C5 DECCC9F8 BEB6891A
+			D13F7CAF`,
+		u: `
+			D56E895D 00CB8A9E A81F0C99 67522018 BCA195A4 85CD5968 7EBB2A3F
+			5ECDA88B`,
+		S: `
+			30ABE90D 7091D461 7EA8B93F 0E649F7F D1CA069B CA471E9D AF46F5FA
+			5C2B31F0 5E650DA3 78C0280F 144E893E D8137111 FF91842C 01CE5E3E
+			D8714B4C B23E2B26 58230C53 15394866 3239A31B 9FDB5033 25F3BEE6
+			5F97D081 AB90C945 3D79C617 58E622F4 FA4A76B9 1DFBCF9A B4DAC654
+			968756F2 0B620B50 0837E297 BD51B2D4 FDE98267 703EDF69 674C3F0E
+			747F910F FEC303BC 15E004EC AADF3782 CD9D2994 ED606B75 30AD0DD3
+			E9D6DE74 36FABEA3 215A13B7 7A7C59D7 FD20AC1D F350AD8B 8CDCAD5D
+			ED683073 DC2DADED A1350E7D 72619BBE 652EE538 13CB7F32 95ADA69F
+			53ED595D E4DE4EA2 3FFA9641 57A42785 FF621726 8F5A9125 51BA4ADB
+			57E8773C`,
+	},
+	{
+		group: 4096,
+		k: `
+			3509477E A9FCA66E ADB7CF7B 1BD0EB50 8F54D398 9A9C9880 06A7D0B3
+			38374DD2`,
+		x: `
+			0065AC38 DFF8BC34 AE0F259E 91FBD0F4 CA2FA430 81C9050C EC7CAC20
+			D015F303`,
+		v: `
+			3D7412CA F4E5C89F 7C5BF86D F61BAA08 4D4C45E3 4A4E7126 F14604EF
+			D17486CC 5C80C970 789DA243 412778B8 E246BEB6 F430023F 40BF38C1
+			A81D0083 F8079882 C29837ED 6D6F41F4 62833818 E6EB8559 A1D432FF
+			3C04BD60 71967FB5 332AB3E7 BD9CE84B C12F125E 12F0BFE0 1D525604
+			932F8AA2 E6472E94 AB6818A1 A92B9328 5FA10D64 7A545BC5 000BCC06
+			4F6ED00A 3DCC809C 45BB2EED 64C1E273 655DFD80 03D0B276 F13EBCAF
+			062DAE55 FD1759D7 BC9B8E4C 19E5FD5C 7A6E649B 81822400 A9391677
+			F29F7116 1E98D19E 5825CF13 EDA2319F 240E0ECA BE6895B5 482F290D
+			F07B9369 686B9EA6 D91FBE64 10DC90B7 5F2AC33E 63DD050D 9188162C
+			F52DB979 3920E2E7 D0BA2E1E D55859CC 77A7C157 868FCEB6 4B7322CC
+			1B228DF7 5C4C2CE1 2363A178 F26BE124 F8C2FDE1 F1626B01 65AD0DF7
+			B3B9AAFD 74F0FDC5 F0516090 865ACC97 6CEFC459 7C755F52 9F0FFBFC
+			FEA36813 CC92FDAD C4B2A20E 570CB327 236E3144 EB66619D 0F5B5AF6
+			9F9D294F 83A0B95C 6EAA7358 FD8F2FD2 8B8B3DD1 A47A2859 7FD48536
+			FF42752C C0CDFADB CAFE879C 9789C5A7 A1637965 6B872C02 EE90AD6C
+			DEB099AB EA3B4460 76865122 9DC3CBE9 8346ADA1 5E6F0DA6 9FFDDDA8
+			81E2C056 617F9872 A4D75EBF 6F0435AD 9542649D B2007B6A 0A283A0B
+			A9DFA043 A49AF231 AA545D8D BC42646A 6BE49BAA 83538846 A332171D
+			29162EEE A622B5A7`,
+		A: `
+			EFC47C04 488796A1 9F9D90AE 91C8E215 C65FEE07 0F7B1D82 9336F4BD
+			D89279D6 DA2B7BA7 DBCFA3A1 2785C890 02E577D1 98252EB9 1845A379
+			051A20DD A3CAA6AC C535D793 5DE098E0 7930C379 9DC3EAF0 DFC19154
+			33CA9437 780079A2 75F79E08 D6ED4835 1E77027B EEE42829 508BE603
+			B2C327B5 450A1712 FC869409 C6B8851D A50BBFF7 1A2541AF 86D5B5E2
+			C7E2F2E2 39C4CFC4 91836427 904015EE F97751B2 F784EA87 62AE6BDD
+			7F2898DD 3E535629 41053F3C 16AF1580 909A5A25 2B5DA34D 91CD4597
+			57BDDDE7 1506E138 F336583B 4571C104 71DD880E A115B492 C97E53BA
+			C575B282 422131DA C1516546 B7DFA5B3 B33F1028 890E9C93 67AB95E9
+			04A60368 1888099E A170BD68 9BE30E58 45E4E825 C2071616 3CA717B9
+			B46F9A4A 03669715 54472CAB 5C944AE7 8A38442D 29CE5E54 CE068DD4
+			5B7B7948 4C743302 0184DD13 B4D2A773 05940D5C A3E6DBB3 DC59E03E
+			964C8EAE 39E59E3E 26C46CDC 78F568B5 E71FC8F7 BEA5AE95 D0306E24
+			F6702620 80D3FD93 4321BF03 6B344A52 FBF59A32 C4CC371A EBBA53A3
+			70A1D2EE B7450A69 4667BC2E 31D24BC9 97B6DD54 F2A60ED7 451A1020
+			F25BF11B FCC0C666 FBAFC9DC 4CDF42E9 5656C987 BA8DEDB5 A49E5E08
+			D303D55A 9F157DEF 215101AF C1D7FCB7 39C3E91B D89703F4 B76C23A2
+			0BC1A0C2 C925795D 4EDC901D 2CC4AD2A 0C63B656 571FC853 A55412E9
+			2FD92FFD 91C330BE`,
+		B: `
+			81EA6700 60A87C6E F310A3A1 D930E4FE BC0670DE F58BC3D3 2D17A430
+			FB1BA2DA B2D6262A E0D7D610 4798BF35 E0347EEE FD2C186B 15499B28
+			78D88F23 B22C2694 991F7BFB 73E8FD3E FDF2FBE2 B96CAE36 16367D65
+			F7B4A71F 7A37AFC8 D5DB77D1 5875F6C6 9BF395A6 50CDFC02 BF27D247
+			7B1CAA59 70CFB96D 389A057D A0A54DD5 3F1C5353 29AC48F1 10CD2DF8
+			037D64E1 786DBE34 538D687F A8BE0139 A9D81650 875CC785 7CEB8FFB
+			35176903 EEB40AD9 C19D91DD FDB25EFD 944FBB4B 0E33DFFF CA439A59
+			52992FEC E8617757 30046AAC 77F6F921 F9B3F4E3 6310FC97 21FDD81E
+			B53F2089 BAE62C2B 80E60FED A4DCE316 DAEEE89B 3CD8965A C9944D85
+			04B2C1DF A3144D4B 5C7ECFD6 9CB9BF81 1871DC62 3F2C83A1 AC784B99
+			394A85A5 464924DE 6A885A59 C4BC8D3B 7A4C98E3 91F65981 4C84DC4C
+			1C9B5B34 6E1085CC 9E4B91CB 5F45176E 801E2FFD 8AE00184 B3B1ECC9
+			D3492858 6B66E4D2 A90F127C 022A14BD 70564D58 EDEA7775 FCA49C4F
+			58668648 81DD06A3 83288C5C CC58E0B1 C82E52CA 204DE242 27C5BD68
+			396C749A A54F5BCE CA81FDC8 CD3E9F6B C58264C4 4E7E2E13 9467DF7E
+			EBE74766 3CEA3173 E37B4053 F4CD03EF B21260F8 B64E970B A29E4566
+			268B09C0 DDA8D058 E8BF260D 7703512B F2A5CFE7 5390FDD6 9F5A6D86
+			8F41171F 6565CB5F DA63C6B0 8D17041A 8E3CB101 A06D51EC 4455F0FE
+			6E55B894 6208D429`,
+		u: `
+			3422AF36 43319934 BFFAD4D8 CE866FB2 3240593C 854A5310 54DE7D6D
+			45DAFAD0`,
+		S: `
+			369581A0 4D4E7C0A 7B0145D3 58ED76BB 91C82074 C3A1F3C1 AC01B46D
+			4D6A4D9E 92E09F42 94A376C1 B2B61124 4313CD9A 03BF6F48 7E748D18
+			AABB573D BBC474EF 033BAA06 6BE06453 70786E4E 1CBA5296 F54CA63E
+			658870F6 4953D367 F4DF34A4 886A563A DCE42F42 4E4C983D 05BD680B
+			5A089147 7260B689 5E295DCD 77744A5E BD4D2C37 BA5D0A0C 372C7070
+			7DF00A51 999DB7C0 2732C480 72559820 8A0B99E8 072FD32C 9036945D
+			BF955D38 A89C9F95 3FF8CAAE 2317EDD5 1732EB2C 1552A684 FC5D9282
+			8EF80649 6BCCB19A E302E09F 4F8F5F71 24AF23DB DA69F751 D65E1089
+			779EE92B 2AAB7943 7BBF4BD7 FD0D2338 A1D37BC9 1F47672C 0F330A7F
+			75EBC289 ABF49208 A793AD2E BC438535 0C73BC4D 0B37EC6A 4D60FC3A
+			4CB000FE 4B524406 98264603 CA13E069 AC24EAD7 7A5B0C98 D239E81D
+			B78FEFCA 2ED9E025 E99324F5 F25728E1 A5632BD9 657A7B7F E1ACA0EF
+			9906B73A 2A0CBB3E 6CEFB469 B8FA09E5 1D230B2B B90DB59C 13E10787
+			8F45A90D ABBFA17D DD7F485C 96A288E8 5B8FEA4E FF8350ED 9F1CD558
+			C7DC9970 2564CCA8 5DE79E3E 78BA0973 A9D391A5 E565942F 34BEA3F4
+			D2C6569A 58072508 4C4834A0 CD869052 8164D2C1 F0E81DBD 8861A5B5
+			ECC53291 4D7AE3AF A817EA2F 22D19B7D CBB8E350 02E07FB2 12558016
+			9FDFBB1F 110C6F9B 2CE5330A 4AC6F3F0 6FB25126 D6949019 7F9BA079
+			561FCC32 6F3DF753`,
+	},
+}
+
+func hexInt(s string) *big.Int {
+	return intFromBytes(bytesFromHexString(s))
+}
+
+func TestSRPVectors(t *testing.T) {
+	if len(srpVectors) != len(knownGroups) {
+		t.Fatalf("%d vectors for %d known groups", len(srpVectors), len(knownGroups))
+	}
+
+	I, P, s := []byte(rfc5054.I), []byte(rfc5054.P), bytesFromHexString(rfc5054.s)
+
+	for _, vec := range srpVectors {
+		params := GetParams(vec.group)
+
+		verifier := ComputeVerifier(params, s, I, P)
+		server := NewSRPServer(params, verifier, bytesFromHexString(rfc5054.b))
+		client := NewSRPClient(params, bytesFromHexString(rfc5054.a))
+		client.ProcessClientChanllenge(I, P, s, server.GetBBytes())
+
+		for _, c := range []struct {
+			name string
+			got  *big.Int
+			want string
+		}{
+			{"k", client.Multiplier, vec.k},
+			{"x", client.X, vec.x},
+			{"v", intFromBytes(verifier), vec.v},
+			{"A", intFromBytes(client.GetABytes()), vec.A},
+			{"B", server.B, vec.B},
+			{"u", client.u, vec.u},
+			{"S", client.s, vec.S},
+		} {
+			if want := hexInt(c.want); c.got.Cmp(want) != 0 {
+				t.Errorf("group %d: %s = %X, want %X", vec.group, c.name, c.got, want)
+			}
+		}
+
+		M2, err := server.ProcessClientProof(I, s, client.GetABytes(), client.GetM1Bytes())
+		if err != nil {
+			t.Fatalf("group %d: server rejected M1: %v", vec.group, err)
+		}
+		if err := client.CheckM2(M2); err != nil {
+			t.Errorf("group %d: client rejected M2: %v", vec.group, err)
+		}
+		if !bytes.Equal(client.GetSessionKey(), server.GetSessionKey()) {
+			t.Errorf("group %d: client and server derived different session keys", vec.group)
+		}
+	}
+}
+
+func TestGetParamsCopies(t *testing.T) {
+	params := GetParams(2048)
+	params.NoUserNameInX = true
+
+	if GetParams(2048).NoUserNameInX {
+		t.Error("changing the params returned by GetParams changed the known group")
+	}
+	if !AppleParams().NoUserNameInX {
+		t.Error("AppleParams does not leave the username out of x")
+	}
+}
+
+// exchange runs the client side against server and returns the client.
+func exchange(t *testing.T, params *SRPParams, server *SRPServer, username, password, salt []byte) *SRPClient {
+	t.Helper()
+
+	client := NewSRPClient(params, nil)
+	client.ProcessClientChanllenge(username, password, salt, server.GetBBytes())
+	return client
+}
+
+func TestSRPRoundTrip(t *testing.T) {
+	username, password, salt := []byte("user@icloud.com"), []byte("hunter2"), []byte("0123456789abcdef")
+
+	for group := range knownGroups {
+		params := GetParams(group)
+		verifier := ComputeVerifier(params, salt, username, password)
+
+		server := NewSRPServer(params, verifier, nil)
+		client := exchange(t, params, server, username, password, salt)
+
+		M2, err := server.ProcessClientProof(username, salt, client.GetABytes(), client.GetM1Bytes())
+		if err != nil {
+			t.Fatalf("group %d: server rejected M1: %v", group, err)
+		}
+		if err := client.CheckM2(M2); err != nil {
+			t.Errorf("group %d: client rejected M2: %v", group, err)
+		}
+
+		forged := append([]byte(nil), M2...)
+		forged[0] ^= 1
+		if err := client.CheckM2(forged); !errors.Is(err, ErrM2Mismatch) {
+			t.Errorf("group %d: CheckM2 of a forged M2 = %v, want ErrM2Mismatch", group, err)
+		}
+		if err := client.CheckM2(nil); !errors.Is(err, ErrM2Mismatch) {
+			t.Errorf("group %d: CheckM2 of no M2 = %v, want ErrM2Mismatch", group, err)
+		}
+
+		server = NewSRPServer(params, verifier, nil)
+		client = exchange(t, params, server, username, []byte("wrong"), salt)
+		if _, err := server.ProcessClientProof(username, salt, client.GetABytes(), client.GetM1Bytes()); !errors.Is(err, ErrM1Mismatch) {
+			t.Errorf("group %d: proof of a wrong password = %v, want ErrM1Mismatch", group, err)
+		}
+	}
+}
+
+func TestSRPRoundTripApple(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	for _, protocol := range []string{ProtocolS2K, ProtocolS2KFO} {
+		verifier, err := AppleVerifier(protocol, []byte("hunter2"), salt, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		passKey, err := PasswordKey(protocol, []byte("hunter2"), salt, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Apple leaves the username out of x but not out of M1
+		server := NewSRPServer(AppleParams(), verifier, nil)
+		client := exchange(t, AppleParams(), server, []byte("user@icloud.com"), passKey, salt)
+
+		M2, err := server.ProcessClientProof([]byte("user@icloud.com"), salt, client.GetABytes(), client.GetM1Bytes())
+		if err != nil {
+			t.Fatalf("%s: server rejected M1: %v", protocol, err)
+		}
+		if err := client.CheckM2(M2); err != nil {
+			t.Errorf("%s: client rejected M2: %v", protocol, err)
+		}
+
+		if _, err := server.ProcessClientProof([]byte("other@icloud.com"), salt, client.GetABytes(), client.GetM1Bytes()); !errors.Is(err, ErrM1Mismatch) {
+			t.Errorf("%s: proof for another username = %v, want ErrM1Mismatch", protocol, err)
+		}
+	}
+}
+
+func TestSRPServerRejectsInvalidA(t *testing.T) {
+	params := GetParams(2048)
+	server := NewSRPServer(params, ComputeVerifier(params, []byte("salt"), []byte("user"), []byte("password")), nil)
+
+	for _, A := range [][]byte{{0}, padToN(params.N, params), new(big.Int).Mul(params.N, big.NewInt(2)).Bytes()} {
+		if _, err := server.ProcessClientProof([]byte("user"), []byte("salt"), A, []byte("M1")); !errors.Is(err, ErrInvalidA) {
+			t.Errorf("A = %X: err = %v, want ErrInvalidA", A, err)
+		}
+	}
+}